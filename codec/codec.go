@@ -0,0 +1,104 @@
+// Package codec encodes and decodes single band tiles. Each format
+// implements the Codec interface and registers itself by name so tilers
+// and readers can select the format at run time.
+package codec
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// Codec converts a single band tile to and from its stored representation.
+type Codec interface {
+	// Name identifies the codec in the registry.
+	Name() string
+	// Ext is the file extension, without the dot, of the encoded tiles.
+	Ext() string
+	Encode(tile *image.Gray) ([]byte, error)
+	// Decode returns a width x height tile from its encoded bytes.
+	Decode(data []byte, width, height int) (*image.Gray, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Codec{}
+)
+
+// Register makes a codec available by name. It panics if a codec with
+// the same name is already registered.
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := registry[c.Name()]; dup {
+		panic("codec: Register called twice for codec " + c.Name())
+	}
+	registry[c.Name()] = c
+}
+
+// Get returns the codec registered as name.
+func Get(name string) (Codec, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown codec: %q", name)
+	}
+	return c, nil
+}
+
+// Names returns the sorted names of the registered codecs.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteFile encodes tile with c and writes it to fName.
+func WriteFile(c Codec, fName string, tile *image.Gray) error {
+	data, err := c.Encode(tile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fName, data, 0644)
+}
+
+// ReadFile reads and decodes the width x height tile stored in fName.
+func ReadFile(c Codec, fName string, width, height int) (*image.Gray, error) {
+	data, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(data, width, height)
+}
+
+// pixels returns the pixels of tile as a contiguous buffer, copying them
+// only when tile is a sub image.
+func pixels(tile *image.Gray) []byte {
+	b := tile.Bounds()
+	width := b.Dx()
+	if tile.Stride == width && len(tile.Pix) == width*b.Dy() {
+		return tile.Pix
+	}
+	pix := make([]byte, width*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		off := tile.PixOffset(b.Min.X, y)
+		copy(pix[(y-b.Min.Y)*width:], tile.Pix[off:off+width])
+	}
+	return pix
+}
+
+// newGray wraps data as a width x height tile.
+func newGray(data []byte, width, height int) (*image.Gray, error) {
+	if len(data) != width*height {
+		return nil, fmt.Errorf("Unexpected tile size: %d bytes, expecting %d", len(data), width*height)
+	}
+	return &image.Gray{Pix: data, Stride: width, Rect: image.Rect(0, 0, width, height)}, nil
+}
@@ -0,0 +1,91 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// gradient returns a width x height tile of smooth values, compressible
+// by every codec.
+func gradient(width, height int) *image.Gray {
+	tile := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			tile.Pix[y*tile.Stride+x] = uint8((x + 2*y) / 8)
+		}
+	}
+	return tile
+}
+
+func TestRoundTrip(t *testing.T) {
+	whole := gradient(400, 400)
+	sub := gradient(500, 450).SubImage(image.Rect(30, 40, 430, 440)).(*image.Gray)
+	for _, name := range Names() {
+		c, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Name() != name {
+			t.Errorf("Codec %q is named %q", name, c.Name())
+		}
+		for _, tile := range []*image.Gray{whole, sub} {
+			data, err := c.Encode(tile)
+			if err != nil {
+				t.Fatalf("Failed encoding %v with %s: %v", tile.Rect, name, err)
+			}
+			got, err := c.Decode(data, 400, 400)
+			if err != nil {
+				t.Fatalf("Failed decoding %v with %s: %v", tile.Rect, name, err)
+			}
+			if got.Rect != image.Rect(0, 0, 400, 400) {
+				t.Fatalf("Decoded %v with %s to %v", tile.Rect, name, got.Rect)
+			}
+			for y := 0; y < 400; y++ {
+				row := tile.Pix[tile.PixOffset(tile.Rect.Min.X, tile.Rect.Min.Y+y):][:400]
+				if !bytes.Equal(got.Pix[y*got.Stride:][:400], row) {
+					t.Fatalf("Row %d of %v differs after a %s round trip", y, tile.Rect, name)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeSize(t *testing.T) {
+	tile := gradient(16, 16)
+	for _, name := range Names() {
+		c, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := c.Encode(tile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Decode(data, 32, 32); err == nil {
+			t.Errorf("Decoding a 16x16 tile as 32x32 with %s succeeded", name)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	for _, name := range []string{"png", "raw", "snappy", "flate", "lzw", "gzip", "lz4"} {
+		if _, err := Get(name); err != nil {
+			t.Errorf("Get(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "jpeg", "PNG", "snpy"} {
+		if c, err := Get(name); err == nil {
+			t.Errorf("Get(%q) = %s, want an error", name, c.Name())
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Registering raw twice did not panic")
+		}
+	}()
+	Register(rawCodec{})
+}
@@ -0,0 +1,68 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/lzw"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// streamCodec adapts the stream compressors of the standard library.
+type streamCodec struct {
+	name, ext string
+	writer    func(w io.Writer) (io.WriteCloser, error)
+	reader    func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (c streamCodec) Name() string { return c.name }
+func (c streamCodec) Ext() string  { return c.ext }
+
+func (c streamCodec) Encode(tile *image.Gray) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.writer(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(pixels(tile)); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c streamCodec) Decode(data []byte, width, height int) (*image.Gray, error) {
+	r, err := c.reader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	pix, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decompressing data: %v", err)
+	}
+	return newGray(pix, width, height)
+}
+
+func init() {
+	Register(streamCodec{
+		name: "flate", ext: "flt",
+		writer: func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, 1) },
+		reader: func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+	})
+	Register(streamCodec{
+		name: "lzw", ext: "lzw",
+		writer: func(w io.Writer) (io.WriteCloser, error) { return lzw.NewWriter(w, lzw.LSB, 8), nil },
+		reader: func(r io.Reader) (io.ReadCloser, error) { return lzw.NewReader(r, lzw.LSB, 8), nil },
+	})
+	Register(streamCodec{
+		name: "gzip", ext: "gzip",
+		writer: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		reader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	})
+}
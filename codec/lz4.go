@@ -0,0 +1,40 @@
+package codec
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/pierrec/lz4"
+)
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() string { return "lz4" }
+func (lz4Codec) Ext() string  { return "lz4" }
+
+func (lz4Codec) Encode(tile *image.Gray) ([]byte, error) {
+	data := pixels(tile)
+	comp := make([]byte, len(data))
+
+	l, err := lz4.CompressBlock(data, comp, 0)
+	if err != nil {
+		return nil, err
+	}
+	if l == 0 {
+		return nil, fmt.Errorf("Tile is not compressible with LZ4")
+	}
+	return comp[:l], nil
+}
+
+func (lz4Codec) Decode(data []byte, width, height int) (*image.Gray, error) {
+	decomp := make([]byte, width*height)
+	l, err := lz4.UncompressBlock(data, decomp, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decompressing data: %v", err)
+	}
+	return newGray(decomp[:l], width, height)
+}
+
+func init() {
+	Register(lz4Codec{})
+}
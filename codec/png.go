@@ -0,0 +1,40 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+)
+
+type pngCodec struct{}
+
+func (pngCodec) Name() string { return "png" }
+func (pngCodec) Ext() string  { return "png" }
+
+func (pngCodec) Encode(tile *image.Gray) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, tile); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (pngCodec) Decode(data []byte, width, height int) (*image.Gray, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		return nil, fmt.Errorf("PNG tile is not a single channel image")
+	}
+	if b := gray.Bounds(); b.Dx() != width || b.Dy() != height {
+		return nil, fmt.Errorf("Unexpected tile size: %dx%d, expecting %dx%d", b.Dx(), b.Dy(), width, height)
+	}
+	return gray, nil
+}
+
+func init() {
+	Register(pngCodec{})
+}
@@ -0,0 +1,43 @@
+package codec
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/golang/snappy"
+)
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "raw" }
+func (rawCodec) Ext() string  { return "raw" }
+
+func (rawCodec) Encode(tile *image.Gray) ([]byte, error) {
+	return pixels(tile), nil
+}
+
+func (rawCodec) Decode(data []byte, width, height int) (*image.Gray, error) {
+	return newGray(data, width, height)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string { return "snappy" }
+func (snappyCodec) Ext() string  { return "snpy" }
+
+func (snappyCodec) Encode(tile *image.Gray) ([]byte, error) {
+	return snappy.Encode(nil, pixels(tile)), nil
+}
+
+func (snappyCodec) Decode(data []byte, width, height int) (*image.Gray, error) {
+	cdata, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decompressing data: %v", err)
+	}
+	return newGray(cdata, width, height)
+}
+
+func init() {
+	Register(rawCodec{})
+	Register(snappyCodec{})
+}
@@ -0,0 +1,406 @@
+// Package geotiff writes multi-band rasters as GeoTIFF files, georeferenced
+// in EPSG:4326, so they can be opened by GDAL and GIS software. Samples
+// can be 8 or 16-bit unsigned integers or 32-bit floats, stored in strips
+// or internal tiles, uncompressed or compressed with deflate.
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Raster is a multi-band raster in EPSG:4326.
+type Raster struct {
+	Width, Height int
+	// Bands hold the Width x Height samples of each band row by row, all
+	// of the same type: []uint8, []uint16 or []float32.
+	Bands []interface{}
+	// GeoTransform maps pixels to coordinates, in the order used by GDAL.
+	GeoTransform [6]float64
+	// NoData is the value of the pixels without data, none if nil.
+	NoData *float64
+}
+
+// GrayRaster returns the raster of 8-bit bands of the same size.
+func GrayRaster(bands []*image.Gray, gt [6]float64) (*Raster, error) {
+	if len(bands) == 0 {
+		return nil, fmt.Errorf("No bands to encode")
+	}
+	b := bands[0].Bounds()
+	r := &Raster{Width: b.Dx(), Height: b.Dy(), GeoTransform: gt}
+	for _, band := range bands {
+		if band.Bounds().Size() != b.Size() {
+			return nil, fmt.Errorf("Bands of different sizes: %v, %v", b.Size(), band.Bounds().Size())
+		}
+		pix := make([]uint8, 0, r.Width*r.Height)
+		for y := band.Rect.Min.Y; y < band.Rect.Max.Y; y++ {
+			i := band.PixOffset(band.Rect.Min.X, y)
+			pix = append(pix, band.Pix[i:i+r.Width]...)
+		}
+		r.Bands = append(r.Bands, pix)
+	}
+	return r, nil
+}
+
+// Compression is the compression of the strips or tiles.
+type Compression int
+
+const (
+	None Compression = iota
+	Deflate
+)
+
+// Options of the encoder.
+type Options struct {
+	Compression Compression
+	// TileSize is the size of the internal tiles, a multiple of 16. The
+	// raster is stored in strips if 0.
+	TileSize int
+}
+
+// TIFF tags
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagPlanarConfig    = 284
+	tagTileWidth       = 322
+	tagTileLength      = 323
+	tagTileOffsets     = 324
+	tagTileByteCounts  = 325
+	tagExtraSamples    = 338
+	tagSampleFormat    = 339
+	tagModelPixelScale = 33550
+	tagModelTiepoint   = 33922
+	tagGeoKeyDirectory = 34735
+	tagGDALNoData      = 42113
+)
+
+// TIFF field types
+const (
+	typeASCII  = 2
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+)
+
+// TIFF compression schemes
+const (
+	compressionNone    = 1
+	compressionDeflate = 8
+)
+
+// TIFF sample formats
+const (
+	sampleUint  = 1
+	sampleFloat = 3
+)
+
+// GeoKeys of a geographic raster in EPSG:4326.
+var geoKeys = []uint16{
+	// Version 1.1.0, 3 keys
+	1, 1, 0, 3,
+	// GTModelTypeGeoKey: ModelTypeGeographic
+	1024, 0, 1, 2,
+	// GTRasterTypeGeoKey: RasterPixelIsArea
+	1025, 0, 1, 1,
+	// GeographicTypeGeoKey: EPSG:4326
+	2048, 0, 1, 4326,
+}
+
+// stripSize is the approximate size in bytes of the strips.
+const stripSize = 64 << 10
+
+// field is an entry of an image file directory.
+type field struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func shorts(tag uint16, v ...uint16) field {
+	f := field{tag: tag, typ: typeShort, count: uint32(len(v)), data: make([]byte, 2*len(v))}
+	for i, x := range v {
+		binary.LittleEndian.PutUint16(f.data[2*i:], x)
+	}
+	return f
+}
+
+func longs(tag uint16, v ...uint32) field {
+	f := field{tag: tag, typ: typeLong, count: uint32(len(v)), data: make([]byte, 4*len(v))}
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(f.data[4*i:], x)
+	}
+	return f
+}
+
+func doubles(tag uint16, v ...float64) field {
+	f := field{tag: tag, typ: typeDouble, count: uint32(len(v)), data: make([]byte, 8*len(v))}
+	for i, x := range v {
+		binary.LittleEndian.PutUint64(f.data[8*i:], math.Float64bits(x))
+	}
+	return f
+}
+
+func ascii(tag uint16, s string) field {
+	return field{tag: tag, typ: typeASCII, count: uint32(len(s) + 1), data: append([]byte(s), 0)}
+}
+
+// repeat returns n copies of v.
+func repeat(v uint16, n int) []uint16 {
+	r := make([]uint16, n)
+	for i := range r {
+		r[i] = v
+	}
+	return r
+}
+
+// sampleType returns the size in bits and the TIFF sample format of the
+// samples of r, checking that every band has the same type and size.
+func (r *Raster) sampleType() (int, uint16, error) {
+	if len(r.Bands) == 0 {
+		return 0, 0, fmt.Errorf("No bands to encode")
+	}
+	if r.Width <= 0 || r.Height <= 0 {
+		return 0, 0, fmt.Errorf("Empty raster: %dx%d", r.Width, r.Height)
+	}
+	var bits int
+	var format uint16
+	for i, band := range r.Bands {
+		var b, n int
+		var f uint16
+		switch v := band.(type) {
+		case []uint8:
+			b, f, n = 8, sampleUint, len(v)
+		case []uint16:
+			b, f, n = 16, sampleUint, len(v)
+		case []float32:
+			b, f, n = 32, sampleFloat, len(v)
+		default:
+			return 0, 0, fmt.Errorf("Unsupported sample type of band %d: %T", i, band)
+		}
+		if i > 0 && (b != bits || f != format) {
+			return 0, 0, fmt.Errorf("Bands of different types: %T, %T", r.Bands[0], band)
+		}
+		if n != r.Width*r.Height {
+			return 0, 0, fmt.Errorf("Band %d has %d samples, expecting %dx%d", i, n, r.Width, r.Height)
+		}
+		bits, format = b, f
+	}
+	return bits, format, nil
+}
+
+// chunk returns the interleaved samples of the w x h rectangle of r at
+// x0, y0. Samples beyond the edges of the raster are zero.
+func (r *Raster) chunk(x0, y0, w, h, bytesPerSample int) []byte {
+	n := len(r.Bands)
+	buf := make([]byte, w*h*n*bytesPerSample)
+	for y := 0; y < h && y0+y < r.Height; y++ {
+		for x := 0; x < w && x0+x < r.Width; x++ {
+			i := (y0+y)*r.Width + x0 + x
+			o := ((y*w + x) * n) * bytesPerSample
+			for _, band := range r.Bands {
+				switch v := band.(type) {
+				case []uint8:
+					buf[o] = v[i]
+				case []uint16:
+					binary.LittleEndian.PutUint16(buf[o:], v[i])
+				case []float32:
+					binary.LittleEndian.PutUint32(buf[o:], math.Float32bits(v[i]))
+				}
+				o += bytesPerSample
+			}
+		}
+	}
+	return buf
+}
+
+func compress(data []byte, c Compression) ([]byte, error) {
+	if c == None {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode writes r to w as a GeoTIFF with the samples of the bands
+// interleaved. A nil opts writes uncompressed strips.
+func Encode(w io.Writer, r *Raster, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	bits, format, err := r.sampleType()
+	if err != nil {
+		return err
+	}
+	gt := r.GeoTransform
+	if gt[2] != 0 || gt[4] != 0 {
+		return fmt.Errorf("Rotated geotransforms not supported: %v", gt)
+	}
+	if opts.TileSize < 0 || opts.TileSize%16 != 0 {
+		return fmt.Errorf("Invalid tile size %d, expecting a multiple of 16", opts.TileSize)
+	}
+	comp := uint16(compressionNone)
+	switch opts.Compression {
+	case None:
+	case Deflate:
+		comp = compressionDeflate
+	default:
+		return fmt.Errorf("Unknown compression: %d", opts.Compression)
+	}
+
+	n := len(r.Bands)
+	fields := []field{
+		longs(tagImageWidth, uint32(r.Width)),
+		longs(tagImageLength, uint32(r.Height)),
+		shorts(tagBitsPerSample, repeat(uint16(bits), n)...),
+		shorts(tagCompression, comp),
+		// MinIsBlack, the bands other than the first being extra samples
+		shorts(tagPhotometric, 1),
+		shorts(tagSamplesPerPixel, uint16(n)),
+		shorts(tagPlanarConfig, 1),
+		shorts(tagSampleFormat, repeat(format, n)...),
+		doubles(tagModelPixelScale, gt[1], -gt[5], 0),
+		doubles(tagModelTiepoint, 0, 0, 0, gt[0], gt[3], 0),
+		shorts(tagGeoKeyDirectory, geoKeys...),
+	}
+	if n > 1 {
+		fields = append(fields, shorts(tagExtraSamples, repeat(0, n-1)...))
+	}
+	if r.NoData != nil {
+		fields = append(fields, ascii(tagGDALNoData, strconv.FormatFloat(*r.NoData, 'g', -1, 64)))
+	}
+
+	// Strips span the whole width, tiles are padded on the right and
+	// bottom edges
+	cw, ch := opts.TileSize, opts.TileSize
+	offsetsTag, countsTag := uint16(tagTileOffsets), uint16(tagTileByteCounts)
+	if cw == 0 {
+		cw, ch = r.Width, stripSize/(r.Width*n*bits/8)
+		if ch < 1 {
+			ch = 1
+		}
+		if ch > r.Height {
+			ch = r.Height
+		}
+		offsetsTag, countsTag = tagStripOffsets, tagStripByteCounts
+		fields = append(fields, longs(tagRowsPerStrip, uint32(ch)))
+	} else {
+		fields = append(fields, longs(tagTileWidth, uint32(cw)), longs(tagTileLength, uint32(ch)))
+	}
+	var chunks [][]byte
+	for y0 := 0; y0 < r.Height; y0 += ch {
+		for x0 := 0; x0 < r.Width; x0 += cw {
+			h := ch
+			if opts.TileSize == 0 && y0+h > r.Height {
+				// The last strip is not padded
+				h = r.Height - y0
+			}
+			data, err := compress(r.chunk(x0, y0, cw, h, bits/8), opts.Compression)
+			if err != nil {
+				return err
+			}
+			chunks = append(chunks, data)
+		}
+	}
+	return write(w, fields, offsetsTag, countsTag, chunks)
+}
+
+// write writes a little endian TIFF with a single image file directory
+// with fields, and the strips or tiles in chunks, listed in the offsetsTag
+// and countsTag fields.
+func write(w io.Writer, fields []field, offsetsTag, countsTag uint16, chunks [][]byte) error {
+	// Offsets of the chunks are known once the size of the directory is
+	counts := make([]uint32, len(chunks))
+	for i, c := range chunks {
+		counts[i] = uint32(len(c))
+	}
+	fields = append(fields, longs(offsetsTag, counts...), longs(countsTag, counts...))
+	sort.Slice(fields, func(i, j int) bool { return fields[i].tag < fields[j].tag })
+
+	// Header, directory, values not fitting in the entries, chunks
+	ifdSize := 2 + 12*len(fields) + 4
+	off := 8 + ifdSize
+	for _, f := range fields {
+		if len(f.data) > 4 {
+			off += len(f.data) + len(f.data)%2
+		}
+	}
+	size := int64(off)
+	for _, c := range chunks {
+		size += int64(len(c))
+	}
+	if size > math.MaxUint32 {
+		return fmt.Errorf("Raster too large for TIFF: %d bytes", size)
+	}
+	offsets := make([]uint32, len(chunks))
+	for i, c := range chunks {
+		offsets[i] = uint32(off)
+		off += len(c)
+	}
+	for i, f := range fields {
+		if f.tag == offsetsTag {
+			fields[i] = longs(offsetsTag, offsets...)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("II")
+	binary.Write(&buf, binary.LittleEndian, uint16(42))
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	binary.Write(&buf, binary.LittleEndian, uint16(len(fields)))
+	var extra bytes.Buffer
+	extraOff := 8 + ifdSize
+	for _, f := range fields {
+		binary.Write(&buf, binary.LittleEndian, f.tag)
+		binary.Write(&buf, binary.LittleEndian, f.typ)
+		binary.Write(&buf, binary.LittleEndian, f.count)
+		if len(f.data) <= 4 {
+			// Values fitting in 4 bytes are stored in the entry
+			var v [4]byte
+			copy(v[:], f.data)
+			buf.Write(v[:])
+			continue
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(extraOff+extra.Len()))
+		extra.Write(f.data)
+		if len(f.data)%2 == 1 {
+			// Values start on word boundaries
+			extra.WriteByte(0)
+		}
+	}
+	// No more directories
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	buf.Write(extra.Bytes())
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if _, err := w.Write(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
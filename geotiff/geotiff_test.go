@@ -0,0 +1,366 @@
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// tiffFile is a decoded single image TIFF.
+type tiffFile struct {
+	// tags holds the numeric values of each field, ascii the strings
+	tags  map[uint16][]float64
+	ascii map[uint16]string
+	// bands holds the samples of each band row by row
+	bands [][]float64
+}
+
+// decodeTIFF reads the fields and the samples of a little endian TIFF
+// with interleaved samples, as written by Encode.
+func decodeTIFF(data []byte) (*tiffFile, error) {
+	le := binary.LittleEndian
+	if len(data) < 8 || string(data[:2]) != "II" || le.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("Not a little endian TIFF")
+	}
+	f := &tiffFile{tags: map[uint16][]float64{}, ascii: map[uint16]string{}}
+	ifd := data[le.Uint32(data[4:]):]
+	n := int(le.Uint16(ifd))
+	for i := 0; i < n; i++ {
+		e := ifd[2+12*i:]
+		tag, typ, count := le.Uint16(e), le.Uint16(e[2:]), int(le.Uint32(e[4:]))
+		size := map[uint16]int{typeASCII: 1, typeShort: 2, typeLong: 4, typeDouble: 8}[typ]
+		if size == 0 {
+			return nil, fmt.Errorf("Unexpected type %d of tag %d", typ, tag)
+		}
+		val := e[8:12]
+		if size*count > 4 {
+			val = data[le.Uint32(e[8:]):]
+		}
+		for j := 0; j < count; j++ {
+			switch typ {
+			case typeASCII:
+				f.ascii[tag] = string(val[:count-1])
+			case typeShort:
+				f.tags[tag] = append(f.tags[tag], float64(le.Uint16(val[2*j:])))
+			case typeLong:
+				f.tags[tag] = append(f.tags[tag], float64(le.Uint32(val[4*j:])))
+			case typeDouble:
+				f.tags[tag] = append(f.tags[tag], math.Float64frombits(le.Uint64(val[8*j:])))
+			}
+		}
+	}
+
+	width, height := int(f.tags[tagImageWidth][0]), int(f.tags[tagImageLength][0])
+	spp := int(f.tags[tagSamplesPerPixel][0])
+	bytesPerSample := int(f.tags[tagBitsPerSample][0]) / 8
+	format := f.tags[tagSampleFormat][0]
+	cw, ch := width, 0
+	offsets, counts := f.tags[tagStripOffsets], f.tags[tagStripByteCounts]
+	if tw, ok := f.tags[tagTileWidth]; ok {
+		cw, ch = int(tw[0]), int(f.tags[tagTileLength][0])
+		offsets, counts = f.tags[tagTileOffsets], f.tags[tagTileByteCounts]
+	} else {
+		ch = int(f.tags[tagRowsPerStrip][0])
+	}
+	if len(offsets) != len(counts) {
+		return nil, fmt.Errorf("%d offsets for %d byte counts", len(offsets), len(counts))
+	}
+	across := (width + cw - 1) / cw
+	f.bands = make([][]float64, spp)
+	for b := range f.bands {
+		f.bands[b] = make([]float64, width*height)
+	}
+	for i := range offsets {
+		chunk := data[int(offsets[i]) : int(offsets[i])+int(counts[i])]
+		if f.tags[tagCompression][0] == compressionDeflate {
+			zr, err := zlib.NewReader(bytes.NewReader(chunk))
+			if err != nil {
+				return nil, err
+			}
+			if chunk, err = ioutil.ReadAll(zr); err != nil {
+				return nil, err
+			}
+		}
+		x0, y0 := i%across*cw, i/across*ch
+		for y := y0; y < y0+ch && y < height; y++ {
+			for x := x0; x < x0+cw && x < width; x++ {
+				for b := 0; b < spp; b++ {
+					o := (((y-y0)*cw+x-x0)*spp + b) * bytesPerSample
+					if o+bytesPerSample > len(chunk) {
+						return nil, fmt.Errorf("Chunk %d too short: %d bytes", i, len(chunk))
+					}
+					var v float64
+					switch {
+					case bytesPerSample == 1:
+						v = float64(chunk[o])
+					case bytesPerSample == 2:
+						v = float64(le.Uint16(chunk[o:]))
+					case format == sampleFloat:
+						v = float64(math.Float32frombits(le.Uint32(chunk[o:])))
+					default:
+						return nil, fmt.Errorf("Unexpected %d byte samples of format %v", bytesPerSample, format)
+					}
+					f.bands[b][y*width+x] = v
+				}
+			}
+		}
+	}
+	return f, nil
+}
+
+// sample is the value of band b at x, y of the test rasters.
+func sample(x, y, b int) float64 {
+	return float64((x*7 + y*13 + b*50) % 251)
+}
+
+// testRaster returns a width x height raster of n bands of samples of
+// type typ: "uint8", "uint16" or "float32".
+func testRaster(width, height, n int, typ string) *Raster {
+	r := &Raster{Width: width, Height: height,
+		GeoTransform: [6]float64{-10, .25, 0, 44, 0, -.125}}
+	for b := 0; b < n; b++ {
+		switch typ {
+		case "uint8":
+			v := make([]uint8, width*height)
+			for i := range v {
+				v[i] = uint8(sample(i%width, i/width, b))
+			}
+			r.Bands = append(r.Bands, v)
+		case "uint16":
+			v := make([]uint16, width*height)
+			for i := range v {
+				v[i] = uint16(sample(i%width, i/width, b) * 200)
+			}
+			r.Bands = append(r.Bands, v)
+		case "float32":
+			v := make([]float32, width*height)
+			for i := range v {
+				v[i] = float32(sample(i%width, i/width, b)) - .5
+			}
+			r.Bands = append(r.Bands, v)
+		}
+	}
+	return r
+}
+
+// expectedSample is the value of band b at x, y of a raster returned by
+// testRaster.
+func expectedSample(x, y, b int, typ string) float64 {
+	switch typ {
+	case "uint16":
+		return sample(x, y, b) * 200
+	case "float32":
+		return sample(x, y, b) - .5
+	}
+	return sample(x, y, b)
+}
+
+func TestEncode(t *testing.T) {
+	const width, height = 300, 50
+	noData := -9999.
+	for _, typ := range []string{"uint8", "uint16", "float32"} {
+		for _, n := range []int{1, 3} {
+			for _, opts := range []*Options{nil, {TileSize: 32}, {Compression: Deflate}, {Compression: Deflate, TileSize: 16}} {
+				name := fmt.Sprintf("%s x%d %+v", typ, n, opts)
+				r := testRaster(width, height, n, typ)
+				r.NoData = &noData
+				var buf bytes.Buffer
+				if err := Encode(&buf, r, opts); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				f, err := decodeTIFF(buf.Bytes())
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+
+				bits := map[string]float64{"uint8": 8, "uint16": 16, "float32": 32}[typ]
+				format := float64(sampleUint)
+				if typ == "float32" {
+					format = sampleFloat
+				}
+				comp := float64(compressionNone)
+				if opts != nil && opts.Compression == Deflate {
+					comp = compressionDeflate
+				}
+				for tag, want := range map[uint16][]float64{
+					tagImageWidth:      {width},
+					tagImageLength:     {height},
+					tagSamplesPerPixel: {float64(n)},
+					tagCompression:     {comp},
+					tagPlanarConfig:    {1},
+					tagModelPixelScale: {.25, .125, 0},
+					tagModelTiepoint:   {0, 0, 0, -10, 44, 0},
+				} {
+					if got := f.tags[tag]; fmt.Sprint(got) != fmt.Sprint(want) {
+						t.Errorf("%s: tag %d is %v, want %v", name, tag, got, want)
+					}
+				}
+				for i := 0; i < n; i++ {
+					if f.tags[tagBitsPerSample][i] != bits || f.tags[tagSampleFormat][i] != format {
+						t.Errorf("%s: sample %d has %v bits of format %v, want %v of %v", name, i,
+							f.tags[tagBitsPerSample][i], f.tags[tagSampleFormat][i], bits, format)
+					}
+				}
+				if got := f.ascii[tagGDALNoData]; got != "-9999" {
+					t.Errorf("%s: no data %q, want %q", name, got, "-9999")
+				}
+				if _, tiled := f.tags[tagTileWidth]; tiled != (opts != nil && opts.TileSize > 0) {
+					t.Errorf("%s: tiled is %v", name, tiled)
+				}
+				if opts == nil && len(f.tags[tagStripOffsets]) < 2 && typ == "float32" && n == 3 {
+					t.Errorf("%s: %d strips, expecting several", name, len(f.tags[tagStripOffsets]))
+				}
+
+				for b := 0; b < n; b++ {
+					for y := 0; y < height; y++ {
+						for x := 0; x < width; x++ {
+							if got, want := f.bands[b][y*width+x], expectedSample(x, y, b, typ); got != want {
+								t.Fatalf("%s: sample %d, %d of band %d is %v, want %v", name, x, y, b, got, want)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestEncodeGeoKeys checks the GeoKey directory of EPSG:4326 rasters.
+func TestEncodeGeoKeys(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, testRaster(20, 10, 1, "uint8"), nil); err != nil {
+		t.Fatal(err)
+	}
+	f, err := decodeTIFF(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := f.tags[tagGeoKeyDirectory]
+	if len(dir) < 4 || len(dir) != 4+4*int(dir[3]) {
+		t.Fatalf("Invalid GeoKey directory: %v", dir)
+	}
+	keys := map[float64]float64{}
+	for i := 4; i < len(dir); i += 4 {
+		if dir[i+1] != 0 || dir[i+2] != 1 {
+			t.Errorf("GeoKey %v is not a short value: %v", dir[i], dir[i:i+4])
+		}
+		keys[dir[i]] = dir[i+3]
+	}
+	for key, want := range map[float64]float64{
+		// Geographic model, pixels as areas, EPSG:4326
+		1024: 2,
+		1025: 1,
+		2048: 4326,
+	} {
+		if keys[key] != want {
+			t.Errorf("GeoKey %v is %v, want %v", key, keys[key], want)
+		}
+	}
+}
+
+// TestEncodeTIFFReader decodes the single band unsigned integer rasters
+// with the TIFF reader of golang.org/x/image. The width is a multiple of
+// the tile size, as the reader does not skip the padding of 8-bit tiles on
+// the right edge.
+func TestEncodeTIFFReader(t *testing.T) {
+	const width, height = 64, 45
+	for _, typ := range []string{"uint8", "uint16"} {
+		for _, opts := range []*Options{nil, {TileSize: 16}, {Compression: Deflate}, {Compression: Deflate, TileSize: 32}} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, testRaster(width, height, 1, typ), opts); err != nil {
+				t.Fatal(err)
+			}
+			im, err := tiff.Decode(&buf)
+			if err != nil {
+				t.Fatalf("%s %+v: %v", typ, opts, err)
+			}
+			if im.Bounds() != image.Rect(0, 0, width, height) {
+				t.Fatalf("%s %+v: decoded %v", typ, opts, im.Bounds())
+			}
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					var got float64
+					switch im := im.(type) {
+					case *image.Gray:
+						got = float64(im.GrayAt(x, y).Y)
+					case *image.Gray16:
+						got = float64(im.Gray16At(x, y).Y)
+					default:
+						t.Fatalf("%s %+v: decoded as %T", typ, opts, im)
+					}
+					if want := expectedSample(x, y, 0, typ); got != want {
+						t.Fatalf("%s %+v: pixel %d, %d is %v, want %v", typ, opts, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestGrayRaster(t *testing.T) {
+	var bands []*image.Gray
+	for b := 0; b < 2; b++ {
+		im := image.NewGray(image.Rect(0, 0, 40, 30))
+		for y := 0; y < 30; y++ {
+			for x := 0; x < 40; x++ {
+				im.Pix[y*im.Stride+x] = uint8(sample(x, y, b))
+			}
+		}
+		bands = append(bands, im.SubImage(image.Rect(5, 10, 25, 20)).(*image.Gray))
+	}
+	r, err := GrayRaster(bands, [6]float64{0, 1, 0, 0, 0, -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Width != 20 || r.Height != 10 || len(r.Bands) != 2 {
+		t.Fatalf("Raster of %dx%d with %d bands, want 20x10 with 2", r.Width, r.Height, len(r.Bands))
+	}
+	for b, band := range r.Bands {
+		for i, v := range band.([]uint8) {
+			if want := uint8(sample(5+i%20, 10+i/20, b)); v != want {
+				t.Fatalf("Sample %d of band %d is %d, want %d", i, b, v, want)
+			}
+		}
+	}
+
+	if _, err := GrayRaster(nil, r.GeoTransform); err == nil {
+		t.Errorf("Raster with no bands succeeded")
+	}
+	if _, err := GrayRaster([]*image.Gray{bands[0], image.NewGray(image.Rect(0, 0, 3, 3))}, r.GeoTransform); err == nil {
+		t.Errorf("Raster of bands of different sizes succeeded")
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	mixed := testRaster(4, 4, 1, "uint8")
+	mixed.Bands = append(mixed.Bands, make([]uint16, 16))
+	short := testRaster(4, 4, 1, "uint8")
+	short.Bands[0] = make([]uint8, 15)
+	rotated := testRaster(4, 4, 1, "uint8")
+	rotated.GeoTransform[2] = .1
+	for _, c := range []struct {
+		name string
+		r    *Raster
+		opts *Options
+	}{
+		{"no bands", &Raster{Width: 4, Height: 4}, nil},
+		{"empty", &Raster{Bands: []interface{}{[]uint8{}}}, nil},
+		{"mixed types", mixed, nil},
+		{"short band", short, nil},
+		{"int samples", &Raster{Width: 1, Height: 1, Bands: []interface{}{[]int{1}}}, nil},
+		{"rotated", rotated, nil},
+		{"tile size", testRaster(4, 4, 1, "uint8"), &Options{TileSize: 20}},
+		{"compression", testRaster(4, 4, 1, "uint8"), &Options{Compression: 7}},
+	} {
+		if err := Encode(ioutil.Discard, c.r, c.opts); err == nil {
+			t.Errorf("Encoding a raster with %s succeeded", c.name)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+
+	"github.com/prl900/earth_data_server/tiles"
+)
+
+// layer is a map layer of a dataset: the composite of its red, green and
+// blue bands, or a single band in gray.
+type layer struct {
+	Name  string
+	Title string
+	d     *tiles.Dataset
+	bands []int
+}
+
+// rgbBands returns the red, green and blue bands of m, or its first band.
+func rgbBands(m *tiles.Manifest) []int {
+	var bands []int
+	for _, name := range []string{"red", "green", "blue"} {
+		b, err := m.Band(name)
+		if err != nil {
+			return []int{0}
+		}
+		bands = append(bands, b)
+	}
+	return bands
+}
+
+// datasetLayers returns the layers of d: the dataset itself, named after
+// it, followed by one layer per band named DATASET:BAND.
+func datasetLayers(d *tiles.Dataset) []layer {
+	ls := []layer{{Name: d.Name, Title: d.Name, d: d, bands: rgbBands(d.Manifest)}}
+	for i, b := range d.Manifest.Bands {
+		ls = append(ls, layer{Name: d.Name + ":" + b, Title: d.Name + " " + b, d: d, bands: []int{i}})
+	}
+	return ls
+}
+
+// sortedDatasets returns the datasets sorted by name.
+func (s *Server) sortedDatasets() []*tiles.Dataset {
+	var ds []*tiles.Dataset
+	for _, d := range s.Datasets {
+		ds = append(ds, d)
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Name < ds[j].Name })
+	return ds
+}
+
+// layer returns the layer called name.
+func (s *Server) layer(name string) (layer, bool) {
+	for _, d := range s.Datasets {
+		for _, l := range datasetLayers(d) {
+			if l.Name == name {
+				return l, true
+			}
+		}
+	}
+	return layer{}, false
+}
+
+// mosaicFunc returns the pixels of a band of a map from its tiles.
+type mosaicFunc func(m *tiles.Manifest, read tiles.TileReader) (*image.Gray, error)
+
+// render returns the bands of l at time index t returned by mosaic.
+func (s *Server) render(l layer, t int, mosaic mosaicFunc) ([]*image.Gray, error) {
+	var ims []*image.Gray
+	for _, b := range l.bands {
+		m, read, err := s.reader(l.d, t, s.defaultFormat(l.d.Manifest), b)
+		if err != nil {
+			return nil, err
+		}
+		im, err := mosaic(m, read)
+		if err != nil {
+			return nil, err
+		}
+		ims = append(ims, im)
+	}
+	return ims, nil
+}
+
+// compose returns the image of the bands of a layer, in gray for a single
+// band. Pixels where every band is nodata are transparent if transparent
+// is set.
+func compose(bands []*image.Gray, nodata uint8, transparent bool) *image.NRGBA {
+	b := bands[0].Bounds()
+	out := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA{A: 255}
+			v := bands[0].GrayAt(x, y).Y
+			c.R, c.G, c.B = v, v, v
+			if len(bands) == 3 {
+				c.G, c.B = bands[1].GrayAt(x, y).Y, bands[2].GrayAt(x, y).Y
+			}
+			if transparent && c.R == nodata && c.G == nodata && c.B == nodata {
+				c = color.NRGBA{}
+			}
+			out.SetNRGBA(x, y, c)
+		}
+	}
+	return out
+}
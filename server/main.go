@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prl900/earth_data_server/codec"
+	"github.com/prl900/earth_data_server/geotiff"
+	"github.com/prl900/earth_data_server/store"
+	"github.com/prl900/earth_data_server/tiles"
+	"golang.org/x/net/context"
+)
+
+// Server keeps the state shared by all the requests: the datasets, each
+// one with the store holding its tiles and the manifest describing them,
+// and the default tile format.
+type Server struct {
+	Datasets map[string]*tiles.Dataset
+	// Default is the dataset served by /region, if any.
+	Default string
+	// Format overrides the default codec of the datasets if not empty.
+	Format string
+	// MaxPixels limits the size of the regions requested as a bbox.
+	MaxPixels int
+	// Cache keeps the decoded tiles shared by all the requests.
+	Cache *tiles.Cache
+}
+
+// reader returns the manifest of the raster of d at time index t, -1 for
+// datasets without time dimension, and the reader of its tiles.
+func (s *Server) reader(d *tiles.Dataset, t int, format string, chann int) (*tiles.Manifest, tiles.TileReader, error) {
+	m, name := d.Manifest, d.Name
+	if t >= 0 {
+		m, name = m.At(t), name+"@"+m.Times[t]
+	}
+	read, err := m.StoreReader(d.Store, format, chann)
+	if err != nil || s.Cache == nil {
+		return m, read, err
+	}
+	// Decoded tiles are the same whatever the codec they are read from
+	return m, s.Cache.Reader(name, chann, read), nil
+}
+
+// parseTime returns the index of the time requested with the time and
+// nearest parameters, the latest one by default, or -1 if m has no time
+// dimension.
+func parseTime(r *http.Request, m *tiles.Manifest) (int, error) {
+	nearest := false
+	if n := r.FormValue("nearest"); n != "" {
+		var err error
+		if nearest, err = strconv.ParseBool(n); err != nil {
+			return -1, fmt.Errorf("Invalid nearest parameter: %q", n)
+		}
+	}
+	return timeIndex(m, r.FormValue("time"), nearest)
+}
+
+// timeIndex returns the index of the time ts of m, the latest one if ts is
+// empty, or -1 if m has no time dimension.
+func timeIndex(m *tiles.Manifest, ts string, nearest bool) (int, error) {
+	if len(m.Times) == 0 {
+		if ts != "" {
+			return -1, fmt.Errorf("Dataset %s has no time dimension", m.Name)
+		}
+		return -1, nil
+	}
+	if ts == "" {
+		return len(m.Times) - 1, nil
+	}
+	return m.Time(ts, nearest)
+}
+
+// datasets lists the manifests of the datasets by name.
+func (s *Server) datasets(w http.ResponseWriter, r *http.Request) {
+	list := map[string]*tiles.Manifest{}
+	for name, d := range s.Datasets {
+		list[name] = d.Manifest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// datasetHandler serves an endpoint of a dataset.
+type datasetHandler func(w http.ResponseWriter, r *http.Request, d *tiles.Dataset)
+
+// endpoints returns the endpoints served for each dataset by name.
+func (s *Server) endpoints() map[string]datasetHandler {
+	return map[string]datasetHandler{
+		"region":     s.region,
+		"timeseries": s.timeSeries,
+		"point":      s.point,
+	}
+}
+
+// dataset routes /datasets/{name}/{endpoint} to the endpoints of the
+// dataset.
+func (s *Server) dataset(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/datasets/"), "/")
+	d, ok := s.Datasets[parts[0]]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown dataset: %q", parts[0]), http.StatusNotFound)
+		return
+	}
+	if len(parts) == 1 || (len(parts) == 2 && parts[1] == "") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.Manifest)
+		return
+	}
+	if h, ok := s.endpoints()[parts[1]]; ok && len(parts) == 2 {
+		h(w, r, d)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// withDefault serves an endpoint from the default dataset.
+func (s *Server) withDefault(h datasetHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := s.Datasets[s.Default]
+		if !ok {
+			http.Error(w, "No default dataset, use /datasets/{name}"+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		h(w, r, d)
+	}
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	var st tiles.CacheStats
+	if s.Cache != nil {
+		st = s.Cache.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+func parseFloat(r *http.Request, name string, min, max float64) (float64, error) {
+	v, err := strconv.ParseFloat(r.FormValue(name), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s parameter: %q", name, r.FormValue(name))
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) || v < min || v > max {
+		return 0, fmt.Errorf("Parameter %s out of range [%v, %v]: %v", name, min, max, v)
+	}
+	return v, nil
+}
+
+// parseSize returns the output size requested for bbox. When only one
+// of width or height is given the other keeps the aspect ratio of the
+// box. It returns zero when no size is requested.
+func parseSize(r *http.Request, bbox tiles.BBox) (int, int, error) {
+	ws, hs := r.FormValue("width"), r.FormValue("height")
+	if ws == "" && hs == "" {
+		return 0, 0, nil
+	}
+	aspect := (bbox.MaxLon - bbox.MinLon) / (bbox.MaxLat - bbox.MinLat)
+	width, height := 0, 0
+	var err error
+	if ws != "" {
+		if width, err = strconv.Atoi(ws); err != nil || width <= 0 {
+			return 0, 0, fmt.Errorf("Invalid width parameter: %q", ws)
+		}
+	}
+	if hs != "" {
+		if height, err = strconv.Atoi(hs); err != nil || height <= 0 {
+			return 0, 0, fmt.Errorf("Invalid height parameter: %q", hs)
+		}
+	}
+	if width == 0 {
+		width = int(math.Max(1, math.Round(float64(height)*aspect)))
+	}
+	if height == 0 {
+		height = int(math.Max(1, math.Round(float64(width)/aspect)))
+	}
+	return width, height, nil
+}
+
+// regionParams holds the parsed parameters of a region request: either
+// a bbox, optionally resampled to width x height, or the 400x400 window
+// centred on lat, lon, of the raster at time index t, encoded as output.
+type regionParams struct {
+	bbox          *tiles.BBox
+	lat, lon      float64
+	width, height int
+	method        string
+	chans         []int
+	format        string
+	t             int
+	output        string
+}
+
+func (p regionParams) String() string {
+	if p.bbox == nil {
+		return fmt.Sprintf("lat=%v lon=%v chan=%v format=%s t=%d output=%s", p.lat, p.lon, p.chans, p.format, p.t, p.output)
+	}
+	return fmt.Sprintf("bbox=%v size=%dx%d resampling=%s chan=%v format=%s t=%d output=%s",
+		*p.bbox, p.width, p.height, p.method, p.chans, p.format, p.t, p.output)
+}
+
+// geotiffOptions are the options of the GeoTIFF responses.
+var geotiffOptions = &geotiff.Options{Compression: geotiff.Deflate, TileSize: 256}
+
+// encodeGeoTIFF encodes bands with geotransform gt as a GeoTIFF.
+func encodeGeoTIFF(bands []*image.Gray, gt tiles.GeoTransform, nodata uint8) ([]byte, error) {
+	r, err := geotiff.GrayRaster(bands, gt.GDAL())
+	if err != nil {
+		return nil, err
+	}
+	nd := float64(nodata)
+	r.NoData = &nd
+	var buf bytes.Buffer
+	if err := geotiff.Encode(&buf, r, geotiffOptions); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fitsPixels reports whether bands of width x height pixels hold at most
+// max values, without overflowing on huge sizes.
+func fitsPixels(width, height, bands, max int) bool {
+	return width > 0 && height > 0 && bands > 0 && width <= max/height/bands
+}
+
+func validMethod(method string) bool {
+	for _, m := range tiles.ResampleMethods() {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) parseRegion(r *http.Request, m *tiles.Manifest) (regionParams, error) {
+	p := regionParams{format: s.Format, method: r.FormValue("resampling")}
+	if p.format == "" {
+		p.format = m.Codecs[0]
+	}
+	var err error
+	p.chans = []int{0}
+	if c := r.FormValue("chan"); c != "" {
+		p.chans = nil
+		for _, name := range strings.Split(c, ",") {
+			chann, err := m.Band(name)
+			if err != nil {
+				return p, fmt.Errorf("Invalid chan parameter: %v", err)
+			}
+			p.chans = append(p.chans, chann)
+		}
+	}
+	switch p.output = r.FormValue("output"); p.output {
+	case "":
+		p.output = "png"
+	case "png", "geotiff":
+	default:
+		return p, fmt.Errorf("Invalid output parameter %q, expecting png or geotiff", p.output)
+	}
+	if p.output == "png" && len(p.chans) > 1 {
+		return p, fmt.Errorf("PNG output has a single channel, use output=geotiff for several")
+	}
+	if f := r.FormValue("format"); f != "" {
+		p.format = f
+	}
+	if p.t, err = parseTime(r, m); err != nil {
+		return p, err
+	}
+
+	if b := r.FormValue("bbox"); b == "" {
+		if p.lat, err = parseFloat(r, "lat", -90, 90); err != nil {
+			return p, err
+		}
+		p.lon, err = parseFloat(r, "lon", -180, 180)
+		return p, err
+	}
+
+	bbox, err := tiles.ParseBBox(r.FormValue("bbox"))
+	if err != nil {
+		return p, err
+	}
+	p.bbox = &bbox
+	if p.width, p.height, err = parseSize(r, bbox); err != nil {
+		return p, err
+	}
+	switch {
+	case p.width == 0 && p.method == "":
+		// Native pixels, no resampling
+		x0, y0, x1, y1 := bbox.Pixels(m.Grid())
+		p.width, p.height = x1-x0, y1-y0
+	case p.width == 0:
+		g := m.Grid()
+		p.width = int(math.Max(1, math.Round((bbox.MaxLon-bbox.MinLon)/g.PixelWidth)))
+		p.height = int(math.Max(1, math.Round((bbox.MaxLat-bbox.MinLat)/-g.PixelHeight)))
+	case p.method == "":
+		p.method = "nearest"
+	}
+	if p.method != "" && !validMethod(p.method) {
+		return p, fmt.Errorf("Unknown resampling method %q, expecting one of: %s",
+			p.method, strings.Join(tiles.ResampleMethods(), ", "))
+	}
+	if !fitsPixels(p.width, p.height, len(p.chans), s.MaxPixels) {
+		return p, fmt.Errorf("Region too large: %dx%d pixels, %d channels", p.width, p.height, len(p.chans))
+	}
+	if p.method != "" {
+		// Datasets without overviews are resampled from full resolution
+		level, rect := m.BBoxSource(bbox, p.width, p.height)
+		if !fitsPixels(rect.Dx(), rect.Dy(), len(p.chans), s.MaxPixels) {
+			return p, fmt.Errorf("Region too large: reading %dx%d pixels of level %d, %d channels",
+				rect.Dx(), rect.Dy(), level, len(p.chans))
+		}
+	}
+	return p, nil
+}
+
+func (s *Server) region(w http.ResponseWriter, r *http.Request, d *tiles.Dataset) {
+	start := time.Now()
+
+	p, err := s.parseRegion(r, d.Manifest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var m *tiles.Manifest
+	var readers []tiles.TileReader
+	for _, chann := range p.chans {
+		var read tiles.TileReader
+		if m, read, err = s.reader(d, p.t, p.format, chann); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		readers = append(readers, read)
+	}
+
+	var ims []*image.Gray
+	var gt tiles.GeoTransform
+	for _, read := range readers {
+		var im *image.Gray
+		switch {
+		case p.bbox == nil:
+			win := m.MosaicWindow(p.lat, p.lon)
+			im, err = m.MosaicRect(r.Context(), 0, win, read)
+			gt = m.Grid().Sub(win.Min.X, win.Min.Y)
+		case p.method == "":
+			im, err = m.MosaicBBox(r.Context(), *p.bbox, read)
+			x0, y0, _, _ := p.bbox.Pixels(m.Grid())
+			gt = m.Grid().Sub(x0, y0)
+		default:
+			im, err = m.MosaicBBoxSize(r.Context(), *p.bbox, p.width, p.height, p.method, read)
+			gt = p.bbox.GeoTransform(p.width, p.height)
+		}
+		if err != nil {
+			log.Printf("Failed generating region %s %v: %v", d.Name, p, err)
+			http.Error(w, "Failed generating region", http.StatusInternalServerError)
+			return
+		}
+		ims = append(ims, im)
+	}
+
+	var data []byte
+	contentType := "image/png"
+	if p.output == "geotiff" {
+		contentType = "image/tiff"
+		data, err = encodeGeoTIFF(ims, gt, m.NoData)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.Name+".tif"))
+	} else {
+		var buf bytes.Buffer
+		err = png.Encode(&buf, ims[0])
+		data = buf.Bytes()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	// Lets clients map the pixels of the region back to coordinates
+	w.Header().Set("X-GeoTransform", gt.String())
+	if p.t >= 0 {
+		w.Header().Set("X-Time", d.Manifest.Times[p.t])
+	}
+	w.Write(data)
+	log.Printf("Region %s %v: %v", d.Name, p, time.Since(start))
+}
+
+// handler returns the routes of the endpoints of the server.
+func (s *Server) handler() *http.ServeMux {
+	mux := http.NewServeMux()
+	for name, h := range s.endpoints() {
+		mux.HandleFunc("/"+name, s.withDefault(h))
+	}
+	mux.HandleFunc("/datasets", s.datasets)
+	mux.HandleFunc("/datasets/", s.dataset)
+	mux.HandleFunc("/stats", s.stats)
+	mux.HandleFunc("/wms", s.wms)
+	mux.HandleFunc("/wcs", s.wcs)
+	mux.HandleFunc("/wmts", s.wmts)
+	mux.HandleFunc("/wmts/1.0.0/", s.wmtsREST)
+	mux.HandleFunc("/xyz/", s.xyz)
+	// Web Mercator tiles of the default dataset at /{z}/{x}/{y}.png
+	mux.HandleFunc("/", s.xyz)
+	return mux
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "HTTP listen address")
+	storeURL := flag.String("store", "file://.", "URL of the tile store of the default dataset, none if empty: "+strings.Join(store.Schemes(), "://, ")+"://")
+	catalog := flag.String("catalog", "", "Directory of the manifests of the datasets served under /datasets/{name}")
+	format := flag.String("format", "", "Tile codec, the first codec of each manifest if empty: "+strings.Join(codec.Names(), ", "))
+	maxPix := flag.Int("maxpix", 4096*4096, "Maximum number of pixels of a region")
+	workers := flag.Int("workers", tiles.Workers, "Number of tiles fetched concurrently by each request")
+	cacheMB := flag.Int("cache", 256, "Size in MB of the decoded tiles cache, 0 to disable it")
+	flag.Parse()
+
+	if *format != "" {
+		if _, err := codec.Get(*format); err != nil {
+			log.Fatal(err)
+		}
+	}
+	tiles.Workers = *workers
+	ctx := context.Background()
+
+	s := &Server{Datasets: map[string]*tiles.Dataset{}, Format: *format, MaxPixels: *maxPix}
+	if *catalog != "" {
+		datasets, err := tiles.LoadCatalog(ctx, *catalog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, d := range datasets {
+			s.Datasets[d.Name] = d
+			log.Printf("Serving dataset %s from %s", d.Name, d.StoreURL)
+		}
+	}
+	if *storeURL != "" {
+		d, err := tiles.OpenDataset(ctx, *storeURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, dup := s.Datasets[d.Name]; dup {
+			log.Fatalf("Dataset %s of %s already in the catalog", d.Name, *storeURL)
+		}
+		s.Datasets[d.Name] = d
+		s.Default = d.Name
+		log.Printf("Serving dataset %s from %s as default", d.Name, d.StoreURL)
+	}
+	for _, d := range s.Datasets {
+		defer d.Close()
+	}
+
+	if *cacheMB > 0 {
+		s.Cache = tiles.NewCache(int64(*cacheMB) << 20)
+	}
+	log.Printf("Listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s.handler()))
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prl900/earth_data_server/store"
+	"github.com/prl900/earth_data_server/tiles"
+	"golang.org/x/net/context"
+)
+
+// seriesTimes are the dates of the series test dataset.
+var seriesTimes = []string{"2004-01-01", "2004-02-01", "2004-03-01"}
+
+// rgbValue is the value of pixel x, y of band b of the rgb test dataset.
+func rgbValue(x, y, b int) uint8 { return uint8(x + y + 50*b) }
+
+// seriesValue is the value of pixel x, y at time index t of the series
+// test dataset.
+func seriesValue(x, y, t int) uint8 { return uint8(x + 2*y + 100*t) }
+
+// testDataset writes the tiles of m, with the value val(x, y, i) at pixel
+// x, y of band or time index i, and its manifest to a mem:// store and
+// opens it.
+func testDataset(t *testing.T, m *tiles.Manifest, val func(x, y, i int) uint8) *tiles.Dataset {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "mem://servertest-"+m.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	raster := func(i int) *image.Gray {
+		img := image.NewGray(image.Rect(0, 0, m.Width, m.Height))
+		for y := 0; y < m.Height; y++ {
+			for x := 0; x < m.Width; x++ {
+				img.Pix[y*img.Stride+x] = val(x, y, i)
+			}
+		}
+		return img
+	}
+	generate := func(m *tiles.Manifest, img *image.Gray, band int) {
+		for _, name := range m.Codecs {
+			if err := m.GenerateTiles(ctx, st, img, band, name); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.GenerateOverviews(ctx, st, img, band, name, "nearest"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(m.Times) == 0 {
+		for b := range m.Bands {
+			generate(m, raster(b), b)
+		}
+	}
+	for i := range m.Times {
+		generate(m.At(i), raster(i), 0)
+	}
+	if err := tiles.WriteManifest(ctx, st, m); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := tiles.OpenDataset(ctx, "mem://servertest-"+m.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// newTestServer returns a server of two datasets in 16x16 tiles: rgb, the
+// default one, a global 90x45 raster of red, green and blue bands, and
+// series, a 20x10 raster of one degree pixels at the north west corner
+// of the globe with a gray band at each of seriesTimes.
+func newTestServer(t *testing.T) *Server {
+	rgb := &tiles.Manifest{Name: "rgb", CRS: "EPSG:4326", GeoTransform: [6]float64{-180, 4, 0, 90, 0, -4},
+		Width: 90, Height: 45, TileSize: 16, Bands: []string{"red", "green", "blue"},
+		DType: "uint8", Codecs: []string{"raw", "png"}, NoData: 255,
+		TileName: "rgb.%02d.%02d.%s", OverviewName: "rgb.l%d.%02d.%02d.%s"}
+	rgb.Levels = rgb.NumLevels()
+	series := &tiles.Manifest{Name: "series", CRS: "EPSG:4326", GeoTransform: [6]float64{-180, 1, 0, 90, 0, -1},
+		Width: 20, Height: 10, TileSize: 16, Bands: []string{"gray"},
+		DType: "uint8", Codecs: []string{"raw"}, NoData: 255, Times: seriesTimes,
+		TileName: "series.%02d.%02d.%s", OverviewName: "series.l%d.%02d.%02d.%s"}
+	series.Levels = series.NumLevels()
+
+	s := &Server{Datasets: map[string]*tiles.Dataset{}, Default: "rgb", MaxPixels: 1000 * 1000,
+		Cache: tiles.NewCache(1 << 20)}
+	s.Datasets["rgb"] = testDataset(t, rgb, rgbValue)
+	s.Datasets["series"] = testDataset(t, series, seriesValue)
+	return s
+}
+
+// addFlat adds the dataset flat to s: rgb without its overviews.
+func addFlat(s *Server) {
+	flat := *s.Datasets["rgb"]
+	m := *flat.Manifest
+	m.Levels = 1
+	flat.Name, flat.Manifest = "flat", &m
+	s.Datasets["flat"] = &flat
+}
+
+// get serves a GET request of target.
+func get(s *Server, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+// checkResponse fails unless the response has the given status and,
+// if not empty, content type.
+func checkResponse(t *testing.T, target string, w *httptest.ResponseRecorder, status int, contentType string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("GET %s: status %d, want %d: %s", target, w.Code, status, strings.TrimSpace(w.Body.String()))
+	}
+	if ct := w.Header().Get("Content-Type"); contentType != "" && ct != contentType {
+		t.Fatalf("GET %s: content type %q, want %q", target, ct, contentType)
+	}
+}
+
+// decodePNG decodes the PNG image of a successful response.
+func decodePNG(t *testing.T, target string, w *httptest.ResponseRecorder) image.Image {
+	t.Helper()
+	checkResponse(t, target, w, http.StatusOK, "image/png")
+	im, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	return im
+}
+
+// grayAt returns the value of the pixel x, y of a gray image.
+func grayAt(im image.Image, x, y int) uint8 {
+	r, _, _, _ := im.At(x, y).RGBA()
+	return uint8(r >> 8)
+}
+
+func TestRegionBBox(t *testing.T) {
+	s := newTestServer(t)
+	for _, c := range []struct {
+		target        string
+		width, height int
+		x0, y0        int
+		band          int
+		gt            string
+	}{
+		// Pixels 45-54, 17-27 of 4 degrees
+		{"/region?bbox=0,-22,40,22", 10, 11, 45, 17, 0, "0,4,0,22,0,-4"},
+		{"/datasets/rgb/region?bbox=0,-22,40,22&chan=blue", 10, 11, 45, 17, 2, "0,4,0,22,0,-4"},
+		// Partially covered pixels are included
+		{"/region?bbox=1,-21,39,21&chan=green", 10, 11, 45, 17, 1, "0,4,0,22,0,-4"},
+		{"/region?bbox=0,-22,40,22&format=png", 10, 11, 45, 17, 0, "0,4,0,22,0,-4"},
+		// Native resolution resampled
+		{"/region?bbox=0,-22,40,22&resampling=nearest", 10, 11, 45, 17, 0, "0,4,0,22,0,-4"},
+	} {
+		w := get(s, c.target)
+		im := decodePNG(t, c.target, w)
+		if b := im.Bounds(); b.Dx() != c.width || b.Dy() != c.height {
+			t.Fatalf("GET %s: %dx%d region, want %dx%d", c.target, b.Dx(), b.Dy(), c.width, c.height)
+		}
+		if gt := w.Header().Get("X-GeoTransform"); gt != c.gt {
+			t.Errorf("GET %s: geotransform %s, want %s", c.target, gt, c.gt)
+		}
+		for y := 0; y < c.height; y++ {
+			for x := 0; x < c.width; x++ {
+				if got, want := grayAt(im, x, y), rgbValue(c.x0+x, c.y0+y, c.band); got != want {
+					t.Fatalf("GET %s: pixel %d, %d is %d, want %d", c.target, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRegionSize(t *testing.T) {
+	s := newTestServer(t)
+	for _, c := range []struct {
+		target        string
+		width, height int
+	}{
+		{"/region?bbox=0,-22,40,22&width=5", 5, 6},
+		{"/region?bbox=0,-22,40,22&height=22", 20, 22},
+		{"/region?bbox=-180,-90,180,90&width=100&height=30&resampling=bilinear", 100, 30},
+		// Within the snapping of a pixel corner
+		{"/region?bbox=0,1.9999999,0.0000001,2&width=2&height=2&resampling=average", 2, 2},
+		{"/region?lat=10&lon=20", tiles.WindowSize, tiles.WindowSize},
+	} {
+		im := decodePNG(t, c.target, get(s, c.target))
+		if b := im.Bounds(); b.Dx() != c.width || b.Dy() != c.height {
+			t.Errorf("GET %s: %dx%d region, want %dx%d", c.target, b.Dx(), b.Dy(), c.width, c.height)
+		}
+	}
+}
+
+func TestRegionGeoTIFF(t *testing.T) {
+	s := newTestServer(t)
+	target := "/datasets/rgb/region?bbox=0,-22,40,22&chan=red,green,blue&output=geotiff"
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "image/tiff")
+	if !bytes.HasPrefix(w.Body.Bytes(), []byte("II*\x00")) {
+		t.Errorf("GET %s: not a TIFF file", target)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="rgb.tif"` {
+		t.Errorf("GET %s: content disposition %q", target, cd)
+	}
+	if gt := w.Header().Get("X-GeoTransform"); gt != "0,4,0,22,0,-4" {
+		t.Errorf("GET %s: geotransform %s", target, gt)
+	}
+}
+
+func TestRegionTime(t *testing.T) {
+	s := newTestServer(t)
+	for _, c := range []struct {
+		query string
+		t     int
+	}{
+		{"", 2},
+		{"&time=2004-02-01", 1},
+		{"&time=200401", 0},
+		{"&time=2004-02-10&nearest=true", 1},
+	} {
+		target := "/datasets/series/region?bbox=-180,80,-170,90" + c.query
+		w := get(s, target)
+		im := decodePNG(t, target, w)
+		if tm := w.Header().Get("X-Time"); tm != seriesTimes[c.t] {
+			t.Errorf("GET %s: time %q, want %s", target, tm, seriesTimes[c.t])
+		}
+		if got, want := grayAt(im, 3, 4), seriesValue(3, 4, c.t); got != want {
+			t.Errorf("GET %s: pixel 3, 4 is %d, want %d", target, got, want)
+		}
+	}
+}
+
+func TestRegionErrors(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxPixels = 100 * 100
+	for _, c := range []struct {
+		target string
+		status int
+	}{
+		{"/region?bbox=0,0,10", http.StatusBadRequest},
+		{"/region?bbox=0,10,10,0", http.StatusBadRequest},
+		{"/region?bbox=0,-100,10,100", http.StatusBadRequest},
+		{"/region?bbox=0,0,x,10", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&width=0", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&height=x", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&width=101&height=100", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&width=60&height=60&chan=red,green,blue&output=geotiff", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&resampling=sinc", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&chan=purple", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&chan=red,green", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&output=jpeg", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&format=jpeg", http.StatusBadRequest},
+		{"/region?bbox=0,0,10,10&time=2004-01-01", http.StatusBadRequest},
+		{"/region?lat=100&lon=0", http.StatusBadRequest},
+		{"/region?lat=0", http.StatusBadRequest},
+		{"/datasets/series/region?bbox=-180,80,-170,90&time=2004-02-10", http.StatusBadRequest},
+		{"/datasets/series/region?bbox=-180,80,-170,90&time=February", http.StatusBadRequest},
+		{"/datasets/series/region?bbox=-180,80,-170,90&nearest=maybe", http.StatusBadRequest},
+		{"/datasets/nowhere/region?bbox=0,0,10,10", http.StatusNotFound},
+		{"/datasets/rgb/nothing", http.StatusNotFound},
+	} {
+		w := get(s, c.target)
+		checkResponse(t, c.target, w, c.status, "")
+	}
+
+	// Resampling reads the full resolution of datasets without overviews
+	addFlat(s)
+	s.MaxPixels = 1000
+	for _, c := range []struct {
+		target string
+		status int
+	}{
+		{"/datasets/rgb/region?bbox=-180,-90,180,90&width=20", http.StatusOK},
+		{"/datasets/flat/region?bbox=-180,-90,180,90&width=20", http.StatusBadRequest},
+		{"/datasets/flat/region?bbox=-180,-90,180,90&width=20&chan=red,green,blue&output=geotiff", http.StatusBadRequest},
+		{"/datasets/flat/region?bbox=0,-22,40,22&width=5", http.StatusOK},
+	} {
+		checkResponse(t, c.target, get(s, c.target), c.status, "")
+	}
+
+	s.Default = ""
+	target := "/region?bbox=0,0,10,10"
+	checkResponse(t, target, get(s, target), http.StatusNotFound, "")
+}
+
+func TestDatasets(t *testing.T) {
+	s := newTestServer(t)
+	w := get(s, "/datasets")
+	checkResponse(t, "/datasets", w, http.StatusOK, "application/json")
+	var list map[string]*tiles.Manifest
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list["rgb"] == nil || list["series"] == nil {
+		t.Fatalf("GET /datasets: %s", w.Body)
+	}
+	if m := list["series"]; m.Width != 20 || len(m.Times) != len(seriesTimes) {
+		t.Errorf("GET /datasets: series is %+v", m)
+	}
+
+	for _, target := range []string{"/datasets/rgb", "/datasets/rgb/"} {
+		w := get(s, target)
+		checkResponse(t, target, w, http.StatusOK, "application/json")
+		var m tiles.Manifest
+		if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Name != "rgb" || len(m.Bands) != 3 {
+			t.Errorf("GET %s: %s", target, w.Body)
+		}
+	}
+	for _, target := range []string{"/datasets/nowhere", "/datasets/rgb/region/more", "/datasets/rgb/manifest.json"} {
+		checkResponse(t, target, get(s, target), http.StatusNotFound, "")
+	}
+}
+
+func TestDatasetEndpoints(t *testing.T) {
+	s := newTestServer(t)
+	// Every endpoint of a dataset is served by /datasets/{name}
+	for _, target := range []string{
+		"/datasets/series/region?bbox=-180,80,-170,90",
+		"/datasets/series/point?lat=85&lon=-175",
+		"/datasets/series/timeseries?lat=85&lon=-175",
+	} {
+		checkResponse(t, target, get(s, target), http.StatusOK, "")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// onlineResource is a link in OGC capabilities documents.
+type onlineResource struct {
+	Type string `xml:"xlink:type,attr"`
+	Href string `xml:"xlink:href,attr"`
+}
+
+func link(href string) onlineResource {
+	return onlineResource{Type: "simple", Href: href}
+}
+
+// xlinkHref is a link in OWS documents.
+type xlinkHref struct {
+	Href string `xml:"xlink:href,attr"`
+}
+
+// owsOperation describes an operation of an OWS service.
+type owsOperation struct {
+	Name string    `xml:"name,attr"`
+	Get  xlinkHref `xml:"ows:DCP>ows:HTTP>ows:Get"`
+}
+
+// owsBBox is a bounding box in OWS documents, with the corners in the
+// order of the axes of its CRS.
+type owsBBox struct {
+	Lower string `xml:"ows:LowerCorner"`
+	Upper string `xml:"ows:UpperCorner"`
+}
+
+// baseURL returns the scheme and host of the server as seen by the client
+// of r.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		scheme = p
+	}
+	return scheme + "://" + r.Host
+}
+
+// serviceURL returns the URL of the endpoint of r, to which the parameters
+// of OGC requests are appended.
+func serviceURL(r *http.Request) string {
+	return baseURL(r) + r.URL.Path + "?"
+}
+
+// ogcQuery returns the parameters of r with lower case names, as the
+// names of OGC parameters are case insensitive.
+func ogcQuery(r *http.Request) url.Values {
+	r.ParseForm()
+	q := url.Values{}
+	for k, v := range r.Form {
+		q[strings.ToLower(k)] = append(q[strings.ToLower(k)], v...)
+	}
+	return q
+}
+
+// OWS exception codes
+const (
+	owsMissingParameter      = "MissingParameterValue"
+	owsInvalidParameter      = "InvalidParameterValue"
+	owsOperationNotSupported = "OperationNotSupported"
+	owsNoApplicableCode      = "NoApplicableCode"
+)
+
+// owsException is an error reported to WMTS and WCS clients in an OWS
+// exception report.
+type owsException struct {
+	Code    string `xml:"exceptionCode,attr"`
+	Locator string `xml:"locator,attr,omitempty"`
+	Text    string `xml:"ExceptionText"`
+	// HTTP status of the report
+	status int
+}
+
+func (e *owsException) Error() string {
+	return e.Text
+}
+
+func owsError(code, locator, format string, args ...interface{}) error {
+	status := http.StatusBadRequest
+	switch code {
+	case owsOperationNotSupported:
+		status = http.StatusNotImplemented
+	case wcsNoSuchCoverage:
+		status = http.StatusNotFound
+	}
+	return &owsException{Code: code, Locator: locator, Text: fmt.Sprintf(format, args...), status: status}
+}
+
+// writeOWSException writes err as an OWS exception report of the given
+// version: 1.1.0 for WMTS, 2.0.0 for WCS.
+func writeOWSException(w http.ResponseWriter, err error, version string) {
+	e, ok := err.(*owsException)
+	if !ok {
+		e = &owsException{Code: owsNoApplicableCode, Text: err.Error(), status: http.StatusInternalServerError}
+	}
+	ns := "http://www.opengis.net/ows/1.1"
+	if version == "2.0.0" {
+		ns = "http://www.opengis.net/ows/2.0"
+	}
+	report := struct {
+		XMLName   xml.Name      `xml:"ExceptionReport"`
+		Xmlns     string        `xml:"xmlns,attr"`
+		Version   string        `xml:"version,attr"`
+		Exception *owsException `xml:"Exception"`
+	}{Xmlns: ns, Version: version, Exception: e}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(e.status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(report)
+}
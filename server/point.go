@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prl900/earth_data_server/codec"
+	"github.com/prl900/earth_data_server/tiles"
+)
+
+// maxPoints bounds the number of points of a /point request.
+const maxPoints = 10000
+
+// pointValue holds the values of every band of the pixel containing a
+// requested point.
+type pointValue struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	// Location of the pixel containing lat, lon
+	X        int     `json:"x"`
+	Y        int     `json:"y"`
+	PixelLat float64 `json:"pixel_lat"`
+	PixelLon float64 `json:"pixel_lon"`
+	Values   []int   `json:"values"`
+}
+
+// pointResponse is the response of a point query.
+type pointResponse struct {
+	Dataset string       `json:"dataset"`
+	Time    string       `json:"time,omitempty"`
+	Bands   []string     `json:"bands"`
+	Points  []pointValue `json:"points"`
+}
+
+// tileFormat returns the codec of the tiles read for r, from the format
+// parameter, the -format flag or the first codec of m.
+func (s *Server) tileFormat(r *http.Request, m *tiles.Manifest) (string, error) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = s.defaultFormat(m)
+	}
+	if _, err := codec.Get(format); err != nil {
+		return "", err
+	}
+	return format, nil
+}
+
+// defaultFormat returns the codec of the tiles of m read by default.
+func (s *Server) defaultFormat(m *tiles.Manifest) string {
+	if s.Format != "" {
+		return s.Format
+	}
+	return m.Codecs[0]
+}
+
+// parsePoints returns the points of the lat and lon parameters or, for
+// batches, of the points parameter as a comma separated list of lat,lon
+// pairs.
+func parsePoints(r *http.Request) ([][2]float64, error) {
+	ps := r.FormValue("points")
+	if ps == "" {
+		lat, err := parseFloat(r, "lat", -90, 90)
+		if err != nil {
+			return nil, err
+		}
+		lon, err := parseFloat(r, "lon", -180, 180)
+		if err != nil {
+			return nil, err
+		}
+		return [][2]float64{{lat, lon}}, nil
+	}
+
+	parts := strings.Split(ps, ",")
+	if len(parts)%2 != 0 {
+		return nil, fmt.Errorf("Invalid points parameter, expecting lat,lon pairs: %q", ps)
+	}
+	if len(parts)/2 > maxPoints {
+		return nil, fmt.Errorf("Too many points: %d, the limit is %d", len(parts)/2, maxPoints)
+	}
+	pts := make([][2]float64, len(parts)/2)
+	for i := range pts {
+		lat, err := strconv.ParseFloat(strings.TrimSpace(parts[2*i]), 64)
+		if err != nil || math.IsNaN(lat) || lat < -90 || lat > 90 {
+			return nil, fmt.Errorf("Invalid latitude of point %d: %q", i, parts[2*i])
+		}
+		lon, err := strconv.ParseFloat(strings.TrimSpace(parts[2*i+1]), 64)
+		if err != nil || math.IsNaN(lon) || lon < -180 || lon > 180 {
+			return nil, fmt.Errorf("Invalid longitude of point %d: %q", i, parts[2*i+1])
+		}
+		pts[i] = [2]float64{lat, lon}
+	}
+	return pts, nil
+}
+
+// point returns the values of every band of the pixels containing the
+// requested points, reading each tile once.
+func (s *Server) point(w http.ResponseWriter, r *http.Request, d *tiles.Dataset) {
+	m := d.Manifest
+	coords, err := parsePoints(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := s.tileFormat(r, m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t, err := parseTime(r, m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := pointResponse{Dataset: d.Name, Bands: m.Bands}
+	if t >= 0 {
+		resp.Time = m.Times[t]
+	}
+	pts := make([]tiles.Point, len(coords))
+	for i, c := range coords {
+		pt, err := m.Locate(c[0], c[1])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pts[i] = pt
+		resp.Points = append(resp.Points, pointValue{Lat: c[0], Lon: c[1], X: pt.X, Y: pt.Y,
+			PixelLat: pt.Lat, PixelLon: pt.Lon, Values: make([]int, len(m.Bands))})
+	}
+
+	for b := range m.Bands {
+		mt, read, err := s.reader(d, t, format, b)
+		if err == nil {
+			var values []uint8
+			if values, err = mt.ReadPoints(r.Context(), pts, read); err == nil {
+				for i, v := range values {
+					resp.Points[i].Values[b] = int(v)
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Failed reading %d points of %s: %v", len(pts), d.Name, err)
+			http.Error(w, "Failed reading points", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPoint(t *testing.T) {
+	s := newTestServer(t)
+	target := "/point?lat=-1&lon=1"
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "application/json")
+	var resp pointResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := pointValue{Lat: -1, Lon: 1, X: 45, Y: 22, PixelLat: 0, PixelLon: 2,
+		Values: []int{int(rgbValue(45, 22, 0)), int(rgbValue(45, 22, 1)), int(rgbValue(45, 22, 2))}}
+	if resp.Dataset != "rgb" || resp.Time != "" || len(resp.Points) != 1 || !reflect.DeepEqual(resp.Points[0], want) {
+		t.Errorf("GET %s: %+v, want point %+v", target, resp, want)
+	}
+
+	target = "/datasets/series/point?lat=85.5&lon=-175.5&time=2004-02-01"
+	w = get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "application/json")
+	resp = pointResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Time != "2004-02-01" || len(resp.Points) != 1 || !reflect.DeepEqual(resp.Points[0].Values, []int{int(seriesValue(4, 4, 1))}) {
+		t.Errorf("GET %s: %+v", target, resp)
+	}
+}
+
+func TestPointBatch(t *testing.T) {
+	s := newTestServer(t)
+	// Points in several tiles, twice in the same pixel and on both sides
+	// of the antimeridian
+	coords := [][2]float64{{-1, 1}, {-1.5, 1.5}, {89, -179}, {-89, 179}, {45, 90}, {0, 180}}
+	pixels := [][2]int{{45, 22}, {45, 22}, {0, 0}, {89, 44}, {67, 11}, {0, 22}}
+	var ps []string
+	for _, c := range coords {
+		ps = append(ps, fmt.Sprintf("%v,%v", c[0], c[1]))
+	}
+	target := "/datasets/rgb/point?points=" + strings.Join(ps, ",")
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "application/json")
+	var resp pointResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Points) != len(coords) {
+		t.Fatalf("GET %s: %d points, want %d", target, len(resp.Points), len(coords))
+	}
+	for i, p := range resp.Points {
+		x, y := pixels[i][0], pixels[i][1]
+		want := []int{int(rgbValue(x, y, 0)), int(rgbValue(x, y, 1)), int(rgbValue(x, y, 2))}
+		if p.Lat != coords[i][0] || p.Lon != coords[i][1] || p.X != x || p.Y != y || !reflect.DeepEqual(p.Values, want) {
+			t.Errorf("GET %s: point %d is %+v, want pixel %d, %d of values %v", target, i, p, x, y, want)
+		}
+	}
+}
+
+func TestPointErrors(t *testing.T) {
+	s := newTestServer(t)
+	for _, target := range []string{
+		"/point?lat=0",
+		"/point?lat=91&lon=0",
+		"/point?lat=0&lon=0&format=jpeg",
+		"/point?lat=0&lon=0&time=2004-01-01",
+		"/point?points=0,0,1",
+		"/point?points=0,0,x,1",
+		"/point?points=0,0,1,200",
+		"/point?points=" + strings.Repeat("0,0,", maxPoints) + "0,0",
+		"/datasets/series/point?lat=85&lon=-175&time=2005-01-01",
+		"/datasets/series/point?lat=0&lon=0",
+	} {
+		name := target
+		if len(name) > 60 {
+			name = name[:60] + "..."
+		}
+		checkResponse(t, name, get(s, target), http.StatusBadRequest, "")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/prl900/earth_data_server/tiles"
+)
+
+// seriesEntry holds the values of every band at one date.
+type seriesEntry struct {
+	Time   string `json:"time,omitempty"`
+	Values []int  `json:"values"`
+}
+
+// timeSeries is the response of a time series query.
+type timeSeries struct {
+	Dataset string  `json:"dataset"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	// Location of the pixel containing lat, lon
+	X        int           `json:"x"`
+	Y        int           `json:"y"`
+	PixelLat float64       `json:"pixel_lat"`
+	PixelLon float64       `json:"pixel_lon"`
+	Bands    []string      `json:"bands"`
+	Series   []seriesEntry `json:"series"`
+}
+
+// readSeries reads the value of every band at every date of d at pt,
+// fetching the tiles concurrently.
+func (s *Server) readSeries(r *http.Request, d *tiles.Dataset, format string, pt tiles.Point) ([]seriesEntry, error) {
+	m := d.Manifest
+	times := []int{-1}
+	if len(m.Times) > 0 {
+		times = times[:0]
+		for t := range m.Times {
+			times = append(times, t)
+		}
+	}
+
+	series := make([]seriesEntry, len(times))
+	errc := make(chan error, 1)
+	sem := make(chan struct{}, tiles.Workers)
+	var wg sync.WaitGroup
+	for i, t := range times {
+		series[i].Values = make([]int, len(m.Bands))
+		if t >= 0 {
+			series[i].Time = m.Times[t]
+		}
+		for b := range m.Bands {
+			wg.Add(1)
+			go func(i, t, b int) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				mt, read, err := s.reader(d, t, format, b)
+				if err == nil {
+					var v uint8
+					v, err = mt.ReadPixel(r.Context(), pt, read)
+					series[i].Values[b] = int(v)
+				}
+				if err != nil {
+					select {
+					case errc <- err:
+					default:
+					}
+				}
+			}(i, t, b)
+		}
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errc:
+		return nil, err
+	default:
+	}
+	return series, nil
+}
+
+// timeSeries returns the values of every band at every date of the pixel
+// containing lat, lon as JSON or, with output=csv, as CSV.
+func (s *Server) timeSeries(w http.ResponseWriter, r *http.Request, d *tiles.Dataset) {
+	m := d.Manifest
+	lat, err := parseFloat(r, "lat", -90, 90)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lon, err := parseFloat(r, "lon", -180, 180)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := s.tileFormat(r, m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	output := r.FormValue("output")
+	if output != "" && output != "json" && output != "csv" {
+		http.Error(w, "Invalid output parameter, expecting json or csv", http.StatusBadRequest)
+		return
+	}
+	pt, err := m.Locate(lat, lon)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := s.readSeries(r, d, format, pt)
+	if err != nil {
+		log.Printf("Failed reading time series %s %v,%v: %v", d.Name, lat, lon, err)
+		http.Error(w, "Failed reading time series", http.StatusInternalServerError)
+		return
+	}
+
+	if output == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write(append([]string{"time"}, m.Bands...))
+		for _, e := range series {
+			row := []string{e.Time}
+			for _, v := range e.Values {
+				row = append(row, strconv.Itoa(v))
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeSeries{Dataset: d.Name, Lat: lat, Lon: lon, X: pt.X, Y: pt.Y,
+		PixelLat: pt.Lat, PixelLon: pt.Lon, Bands: m.Bands, Series: series})
+}
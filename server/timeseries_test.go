@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTimeSeries(t *testing.T) {
+	s := newTestServer(t)
+	target := "/datasets/series/timeseries?lat=85.5&lon=-175.5"
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "application/json")
+	var ts timeSeries
+	if err := json.Unmarshal(w.Body.Bytes(), &ts); err != nil {
+		t.Fatal(err)
+	}
+	if ts.Dataset != "series" || ts.X != 4 || ts.Y != 4 || ts.PixelLat != 85.5 || ts.PixelLon != -175.5 {
+		t.Errorf("GET %s: %+v", target, ts)
+	}
+	if !reflect.DeepEqual(ts.Bands, []string{"gray"}) || len(ts.Series) != len(seriesTimes) {
+		t.Fatalf("GET %s: %+v", target, ts)
+	}
+	for i, e := range ts.Series {
+		if e.Time != seriesTimes[i] || !reflect.DeepEqual(e.Values, []int{int(seriesValue(4, 4, i))}) {
+			t.Errorf("GET %s: entry %d is %+v", target, i, e)
+		}
+	}
+
+	// Datasets without time dimension have a single entry
+	target = "/timeseries?lat=-1&lon=1&format=png"
+	w = get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "application/json")
+	ts = timeSeries{}
+	if err := json.Unmarshal(w.Body.Bytes(), &ts); err != nil {
+		t.Fatal(err)
+	}
+	want := []seriesEntry{{Values: []int{int(rgbValue(45, 22, 0)), int(rgbValue(45, 22, 1)), int(rgbValue(45, 22, 2))}}}
+	if ts.Dataset != "rgb" || !reflect.DeepEqual(ts.Series, want) {
+		t.Errorf("GET %s: %+v, want series %+v", target, ts, want)
+	}
+}
+
+func TestTimeSeriesCSV(t *testing.T) {
+	s := newTestServer(t)
+	target := "/datasets/series/timeseries?lat=85.5&lon=-175.5&output=csv"
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "text/csv")
+	rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"time", "gray"}}
+	for i, tm := range seriesTimes {
+		want = append(want, []string{tm, strconv.Itoa(int(seriesValue(4, 4, i)))})
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("GET %s: %q, want %q", target, rows, want)
+	}
+}
+
+func TestTimeSeriesErrors(t *testing.T) {
+	s := newTestServer(t)
+	for _, target := range []string{
+		"/datasets/series/timeseries?lon=-175",
+		"/datasets/series/timeseries?lat=85&lon=-181",
+		"/datasets/series/timeseries?lat=x&lon=-175",
+		"/datasets/series/timeseries?lat=85&lon=-175&output=xml",
+		"/datasets/series/timeseries?lat=85&lon=-175&format=jpeg",
+		// Outside the regional dataset
+		"/datasets/series/timeseries?lat=0&lon=0",
+	} {
+		checkResponse(t, target, get(s, target), http.StatusBadRequest, "")
+	}
+	target := "/datasets/nowhere/timeseries?lat=85&lon=-175"
+	checkResponse(t, target, get(s, target), http.StatusNotFound, "")
+}
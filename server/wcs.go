@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prl900/earth_data_server/tiles"
+)
+
+// WCS 2.0 exception codes
+const (
+	wcsNoSuchCoverage    = "NoSuchCoverage"
+	wcsInvalidAxisLabel  = "InvalidAxisLabel"
+	wcsInvalidSubsetting = "InvalidSubsetting"
+	wcsNoSuchField       = "NoSuchField"
+)
+
+const (
+	crs4326 = "http://www.opengis.net/def/crs/EPSG/0/4326"
+	// crs4326Time adds the time axis, in days, to EPSG:4326
+	crs4326Time = "http://www.opengis.net/def/crs-compound?1=" + crs4326 + "&2=http://www.opengis.net/def/crs/OGC/0/AnsiDate"
+	// Profiles of the WCS standard supported
+	wcsCore          = "http://www.opengis.net/spec/WCS/2.0/conf/core"
+	wcsKVP           = "http://www.opengis.net/spec/WCS_protocol-binding_get-kvp/1.0/conf/get-kvp"
+	wcsRangeSubset   = "http://www.opengis.net/spec/WCS_service-extension_range-subsetting/1.0/conf/record-subsetting"
+	wcsGeoTIFFFormat = "http://www.opengis.net/spec/GMLCOV_geotiff-coverages/1.0/conf/geotiff-coverage"
+)
+
+type wcsCoverageSummary struct {
+	WGS84BBox  owsBBox `xml:"ows:WGS84BoundingBox"`
+	CoverageID string  `xml:"wcs:CoverageId"`
+	Subtype    string  `xml:"wcs:CoverageSubtype"`
+}
+
+type wcsCapabilities struct {
+	XMLName    xml.Name             `xml:"wcs:Capabilities"`
+	Wcs        string               `xml:"xmlns:wcs,attr"`
+	Ows        string               `xml:"xmlns:ows,attr"`
+	XLink      string               `xml:"xmlns:xlink,attr"`
+	Version    string               `xml:"version,attr"`
+	Title      string               `xml:"ows:ServiceIdentification>ows:Title"`
+	Type       string               `xml:"ows:ServiceIdentification>ows:ServiceType"`
+	TypeVer    string               `xml:"ows:ServiceIdentification>ows:ServiceTypeVersion"`
+	Profiles   []string             `xml:"ows:ServiceIdentification>ows:Profile"`
+	Operations []owsOperation       `xml:"ows:OperationsMetadata>ows:Operation"`
+	Formats    []string             `xml:"wcs:ServiceMetadata>wcs:formatSupported"`
+	Coverages  []wcsCoverageSummary `xml:"wcs:Contents>wcs:CoverageSummary"`
+}
+
+type gmlEnvelope struct {
+	SrsName    string `xml:"srsName,attr"`
+	AxisLabels string `xml:"axisLabels,attr"`
+	UomLabels  string `xml:"uomLabels,attr"`
+	Dimension  int    `xml:"srsDimension,attr"`
+	Lower      string `xml:"gml:lowerCorner"`
+	Upper      string `xml:"gml:upperCorner"`
+}
+
+type gmlOffsetVector struct {
+	SrsName string `xml:"srsName,attr"`
+	Vector  string `xml:",chardata"`
+}
+
+type gmlPoint struct {
+	ID      string `xml:"gml:id,attr"`
+	SrsName string `xml:"srsName,attr"`
+	Pos     string `xml:"gml:pos"`
+}
+
+type gmlRectifiedGrid struct {
+	ID            string            `xml:"gml:id,attr"`
+	Dimension     int               `xml:"dimension,attr"`
+	Low           string            `xml:"gml:limits>gml:GridEnvelope>gml:low"`
+	High          string            `xml:"gml:limits>gml:GridEnvelope>gml:high"`
+	AxisLabels    string            `xml:"gml:axisLabels"`
+	Origin        gmlPoint          `xml:"gml:origin>gml:Point"`
+	OffsetVectors []gmlOffsetVector `xml:"gml:offsetVector"`
+}
+
+type gmlGridAxis struct {
+	OffsetVector gmlOffsetVector `xml:"gmlrgrid:GeneralGridAxis>gmlrgrid:offsetVector"`
+	Coefficients string          `xml:"gmlrgrid:GeneralGridAxis>gmlrgrid:coefficients"`
+	Spanned      string          `xml:"gmlrgrid:GeneralGridAxis>gmlrgrid:gridAxesSpanned"`
+	Rule         string          `xml:"gmlrgrid:GeneralGridAxis>gmlrgrid:sequenceRule"`
+}
+
+// gmlReferenceableGrid is the grid of coverages with a time dimension,
+// whose dates are irregularly spaced.
+type gmlReferenceableGrid struct {
+	ID         string        `xml:"gml:id,attr"`
+	Dimension  int           `xml:"dimension,attr"`
+	Low        string        `xml:"gml:limits>gml:GridEnvelope>gml:low"`
+	High       string        `xml:"gml:limits>gml:GridEnvelope>gml:high"`
+	AxisLabels string        `xml:"gml:axisLabels"`
+	Origin     gmlPoint      `xml:"gmlrgrid:origin>gml:Point"`
+	Axes       []gmlGridAxis `xml:"gmlrgrid:generalGridAxis"`
+}
+
+type sweNilValue struct {
+	Reason string `xml:"reason,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type sweUom struct {
+	Code string `xml:"code,attr"`
+}
+
+type sweQuantity struct {
+	Definition string        `xml:"definition,attr"`
+	NilValues  []sweNilValue `xml:"swe:nilValues>swe:NilValues>swe:nilValue"`
+	Uom        sweUom        `xml:"swe:uom"`
+}
+
+type sweField struct {
+	Name     string      `xml:"name,attr"`
+	Quantity sweQuantity `xml:"swe:Quantity"`
+}
+
+type wcsCoverageDescription struct {
+	ID         string                `xml:"gml:id,attr"`
+	Envelope   gmlEnvelope           `xml:"gml:boundedBy>gml:Envelope"`
+	CoverageID string                `xml:"wcs:CoverageId"`
+	Grid       *gmlRectifiedGrid     `xml:"gml:domainSet>gml:RectifiedGrid"`
+	TimeGrid   *gmlReferenceableGrid `xml:"gml:domainSet>gmlrgrid:ReferenceableGridByVectors"`
+	Fields     []sweField            `xml:"gmlcov:rangeType>swe:DataRecord>swe:field"`
+	Subtype    string                `xml:"wcs:ServiceParameters>wcs:CoverageSubtype"`
+	Format     string                `xml:"wcs:ServiceParameters>wcs:nativeFormat"`
+}
+
+type wcsCoverageDescriptions struct {
+	XMLName      xml.Name                 `xml:"wcs:CoverageDescriptions"`
+	Wcs          string                   `xml:"xmlns:wcs,attr"`
+	Gml          string                   `xml:"xmlns:gml,attr"`
+	Gmlcov       string                   `xml:"xmlns:gmlcov,attr"`
+	Gmlrgrid     string                   `xml:"xmlns:gmlrgrid,attr"`
+	Swe          string                   `xml:"xmlns:swe,attr"`
+	Descriptions []wcsCoverageDescription `xml:"wcs:CoverageDescription"`
+}
+
+func (s *Server) wcsCapabilities(w http.ResponseWriter, r *http.Request) {
+	href := baseURL(r) + "/wcs?"
+	caps := wcsCapabilities{Wcs: "http://www.opengis.net/wcs/2.0", Ows: "http://www.opengis.net/ows/2.0",
+		XLink: "http://www.w3.org/1999/xlink", Version: "2.0.1",
+		Title: "Earth data server", Type: "OGC WCS", TypeVer: "2.0.1",
+		Profiles: []string{wcsCore, wcsKVP, wcsRangeSubset, wcsGeoTIFFFormat},
+		Formats:  []string{"image/tiff"}}
+	for _, op := range []string{"GetCapabilities", "DescribeCoverage", "GetCoverage"} {
+		caps.Operations = append(caps.Operations, owsOperation{Name: op, Get: xlinkHref{href}})
+	}
+	for _, d := range s.sortedDatasets() {
+		b := d.Manifest.BBox()
+		caps.Coverages = append(caps.Coverages, wcsCoverageSummary{CoverageID: d.Name, Subtype: coverageSubtype(d.Manifest),
+			WGS84BBox: owsBBox{Lower: fmt.Sprintf("%v %v", b.MinLon, b.MinLat), Upper: fmt.Sprintf("%v %v", b.MaxLon, b.MaxLat)}})
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(caps)
+}
+
+// coverageSubtype returns the type of the coverage of m: a rectified grid
+// or, with a time dimension, a referenceable grid.
+func coverageSubtype(m *tiles.Manifest) string {
+	if len(m.Times) > 0 {
+		return "ReferenceableGridCoverage"
+	}
+	return "RectifiedGridCoverage"
+}
+
+// describeCoverage describes the grid and bands of a dataset. Axes follow
+// EPSG:4326, latitude first, followed by the dates of datasets with a time
+// dimension.
+func describeCoverage(d *tiles.Dataset) wcsCoverageDescription {
+	m := d.Manifest
+	b, g := m.BBox(), m.Grid()
+	// Centre of the top left pixel
+	lon, lat := g.Coord(.5, .5)
+	desc := wcsCoverageDescription{ID: d.Name, CoverageID: d.Name, Subtype: coverageSubtype(m), Format: "image/tiff",
+		Envelope: gmlEnvelope{SrsName: crs4326, AxisLabels: "Lat Long", UomLabels: "deg deg", Dimension: 2,
+			Lower: fmt.Sprintf("%v %v", b.MinLat, b.MinLon), Upper: fmt.Sprintf("%v %v", b.MaxLat, b.MaxLon)}}
+	if len(m.Times) == 0 {
+		desc.Grid = &gmlRectifiedGrid{ID: d.Name + "-grid", Dimension: 2, Low: "0 0",
+			High: fmt.Sprintf("%d %d", m.Height-1, m.Width-1), AxisLabels: "i j",
+			Origin: gmlPoint{ID: d.Name + "-origin", SrsName: crs4326, Pos: fmt.Sprintf("%v %v", lat, lon)},
+			OffsetVectors: []gmlOffsetVector{
+				{SrsName: crs4326, Vector: fmt.Sprintf("%v 0", g.PixelHeight)},
+				{SrsName: crs4326, Vector: fmt.Sprintf("0 %v", g.PixelWidth)},
+			}}
+	} else {
+		first, last := m.Times[0], m.Times[len(m.Times)-1]
+		desc.Envelope = gmlEnvelope{SrsName: crs4326Time, AxisLabels: "Lat Long ansi", UomLabels: "deg deg d", Dimension: 3,
+			Lower: fmt.Sprintf("%v %v %q", b.MinLat, b.MinLon, first), Upper: fmt.Sprintf("%v %v %q", b.MaxLat, b.MaxLon, last)}
+		// The dates are the coefficients of the time axis
+		var dates []string
+		for _, t := range m.Times {
+			dates = append(dates, strconv.Quote(t))
+		}
+		desc.TimeGrid = &gmlReferenceableGrid{ID: d.Name + "-grid", Dimension: 3, Low: "0 0 0",
+			High: fmt.Sprintf("%d %d %d", m.Height-1, m.Width-1, len(m.Times)-1), AxisLabels: "i j k",
+			Origin: gmlPoint{ID: d.Name + "-origin", SrsName: crs4326Time, Pos: fmt.Sprintf("%v %v %q", lat, lon, first)},
+			Axes: []gmlGridAxis{
+				{OffsetVector: gmlOffsetVector{SrsName: crs4326Time, Vector: fmt.Sprintf("%v 0 0", g.PixelHeight)}, Spanned: "i", Rule: "Linear"},
+				{OffsetVector: gmlOffsetVector{SrsName: crs4326Time, Vector: fmt.Sprintf("0 %v 0", g.PixelWidth)}, Spanned: "j", Rule: "Linear"},
+				{OffsetVector: gmlOffsetVector{SrsName: crs4326Time, Vector: "0 0 1"}, Coefficients: strings.Join(dates, " "),
+					Spanned: "k", Rule: "Linear"},
+			}}
+	}
+	for _, band := range m.Bands {
+		desc.Fields = append(desc.Fields, sweField{Name: band, Quantity: sweQuantity{
+			Definition: "http://www.opengis.net/def/dataType/OGC/0/unsignedByte",
+			NilValues:  []sweNilValue{{Reason: "http://www.opengis.net/def/nil/OGC/0/missing", Value: strconv.Itoa(int(m.NoData))}},
+			Uom:        sweUom{Code: "10^0"}}})
+	}
+	return desc
+}
+
+// coverages returns the datasets of the comma separated coverage ids.
+func (s *Server) coverages(ids string) ([]*tiles.Dataset, error) {
+	if ids == "" {
+		return nil, owsError(owsMissingParameter, "coverageId", "Missing coverageId parameter")
+	}
+	var ds []*tiles.Dataset
+	for _, id := range strings.Split(ids, ",") {
+		d, ok := s.Datasets[id]
+		if !ok {
+			return nil, owsError(wcsNoSuchCoverage, "coverageId", "Unknown coverage: %q", id)
+		}
+		ds = append(ds, d)
+	}
+	return ds, nil
+}
+
+func (s *Server) wcsDescribeCoverage(w http.ResponseWriter, r *http.Request, q url.Values) {
+	ds, err := s.coverages(q.Get("coverageid"))
+	if err != nil {
+		writeOWSException(w, err, "2.0.0")
+		return
+	}
+	descs := wcsCoverageDescriptions{Wcs: "http://www.opengis.net/wcs/2.0", Gml: "http://www.opengis.net/gml/3.2",
+		Gmlcov: "http://www.opengis.net/gmlcov/1.0", Gmlrgrid: "http://www.opengis.net/gml/3.3/rgrid",
+		Swe: "http://www.opengis.net/swe/2.0"}
+	for _, d := range ds {
+		descs.Descriptions = append(descs.Descriptions, describeCoverage(d))
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(descs)
+}
+
+// coverageRequest holds the parsed parameters of a GetCoverage request.
+type coverageRequest struct {
+	d     *tiles.Dataset
+	bbox  tiles.BBox
+	bands []int
+	t     int
+}
+
+// parseSubset parses a subset parameter such as Lat(36,44) into its axis
+// and bounds, "*" standing for the coverage limit.
+func parseSubset(s string) (axis string, low, high string, err error) {
+	open := strings.Index(s, "(")
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return "", "", "", owsError(wcsInvalidSubsetting, "subset", "Invalid subset %q, expecting axis(low,high)", s)
+	}
+	axis = s[:open]
+	bounds := strings.Split(s[open+1:len(s)-1], ",")
+	if len(bounds) == 1 {
+		return axis, strings.Trim(bounds[0], `"`), "", nil
+	}
+	if len(bounds) != 2 {
+		return "", "", "", owsError(wcsInvalidSubsetting, "subset", "Invalid subset %q, expecting axis(low,high)", s)
+	}
+	return axis, strings.Trim(bounds[0], `"`), strings.Trim(bounds[1], `"`), nil
+}
+
+// trim narrows [*lo, *hi] to the bounds of a subset.
+func trim(subset, low, high string, lo, hi *float64) error {
+	if high == "" {
+		return owsError(wcsInvalidSubsetting, "subset", "Slicing not supported on %s, expecting a trim such as Lat(36,44)", subset)
+	}
+	for i, v := range []string{low, high} {
+		if v == "*" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return owsError(wcsInvalidSubsetting, "subset", "Invalid bound %q of %s", v, subset)
+		}
+		if i == 0 {
+			*lo = math.Max(*lo, f)
+		} else {
+			*hi = math.Min(*hi, f)
+		}
+	}
+	if *lo >= *hi {
+		return owsError(wcsInvalidSubsetting, "subset", "Empty or outside coverage subset: %s", subset)
+	}
+	return nil
+}
+
+// parseRangeSubset returns the bands of a comma separated list of band
+// names or intervals of bands such as red:blue.
+func parseRangeSubset(m *tiles.Manifest, rs string) ([]int, error) {
+	if rs == "" {
+		var bands []int
+		for i := range m.Bands {
+			bands = append(bands, i)
+		}
+		return bands, nil
+	}
+	var bands []int
+	for _, item := range strings.Split(rs, ",") {
+		ends := strings.SplitN(item, ":", 2)
+		var idx []int
+		for _, e := range ends {
+			b, err := m.Band(e)
+			if err != nil {
+				return nil, owsError(wcsNoSuchField, "rangeSubset", "%v", err)
+			}
+			idx = append(idx, b)
+		}
+		if len(idx) == 1 {
+			idx = append(idx, idx[0])
+		}
+		if idx[0] > idx[1] {
+			return nil, owsError(wcsNoSuchField, "rangeSubset", "Invalid band interval: %q", item)
+		}
+		for b := idx[0]; b <= idx[1]; b++ {
+			bands = append(bands, b)
+		}
+	}
+	return bands, nil
+}
+
+func (s *Server) parseCoverage(q url.Values) (coverageRequest, error) {
+	var req coverageRequest
+	ds, err := s.coverages(q.Get("coverageid"))
+	if err != nil {
+		return req, err
+	}
+	if len(ds) != 1 {
+		return req, owsError(owsInvalidParameter, "coverageId", "Expecting a single coverage")
+	}
+	req.d = ds[0]
+	m := req.d.Manifest
+	if f := q.Get("format"); f != "" && f != "image/tiff" {
+		return req, owsError(owsInvalidParameter, "format", "Unsupported format %q, expecting image/tiff", f)
+	}
+	if c := q.Get("subsettingcrs"); c != "" && c != crs4326 {
+		return req, owsError(owsInvalidParameter, "subsettingCrs", "Unsupported CRS %q, expecting %s", c, crs4326)
+	}
+
+	req.bbox = m.BBox()
+	ts := ""
+	for _, subset := range q["subset"] {
+		axis, low, high, err := parseSubset(subset)
+		if err != nil {
+			return req, err
+		}
+		switch strings.ToLower(axis) {
+		case "lat":
+			err = trim(subset, low, high, &req.bbox.MinLat, &req.bbox.MaxLat)
+		case "long", "lon":
+			err = trim(subset, low, high, &req.bbox.MinLon, &req.bbox.MaxLon)
+		case "time", "ansi":
+			if high != "" {
+				err = owsError(wcsInvalidSubsetting, "subset", "Only slicing supported on %s, such as time(\"2004-02-01\")", axis)
+			}
+			ts = low
+		default:
+			err = owsError(wcsInvalidAxisLabel, "subset", "Unknown axis %q, expecting Lat, Long or time", axis)
+		}
+		if err != nil {
+			return req, err
+		}
+	}
+	if req.t, err = timeIndex(m, ts, true); err != nil {
+		return req, owsError(wcsInvalidSubsetting, "subset", "%v", err)
+	}
+	if req.bands, err = parseRangeSubset(m, q.Get("rangesubset")); err != nil {
+		return req, err
+	}
+	x0, y0, x1, y1 := req.bbox.Pixels(m.Grid())
+	if !fitsPixels(x1-x0, y1-y0, len(req.bands), s.MaxPixels) {
+		return req, owsError(owsInvalidParameter, "subset", "Coverage too large: %dx%d pixels, %d bands", x1-x0, y1-y0, len(req.bands))
+	}
+	return req, nil
+}
+
+// getCoverage returns the native pixels of the bands of a coverage
+// subset as a GeoTIFF.
+func (s *Server) getCoverage(r *http.Request, req coverageRequest) ([]byte, error) {
+	var bands []*image.Gray
+	for _, b := range req.bands {
+		m, read, err := s.reader(req.d, req.t, s.defaultFormat(req.d.Manifest), b)
+		if err != nil {
+			return nil, err
+		}
+		im, err := m.MosaicBBox(r.Context(), req.bbox, read)
+		if err != nil {
+			return nil, err
+		}
+		bands = append(bands, im)
+	}
+	m := req.d.Manifest
+	x0, y0, _, _ := req.bbox.Pixels(m.Grid())
+	return encodeGeoTIFF(bands, m.Grid().Sub(x0, y0), m.NoData)
+}
+
+// wcs serves the KVP encoding of WCS 2.0 at /wcs.
+func (s *Server) wcs(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	q := ogcQuery(r)
+	if svc := q.Get("service"); svc != "" && strings.ToUpper(svc) != "WCS" {
+		writeOWSException(w, owsError(owsInvalidParameter, "service", "Unsupported service %q, expecting WCS", svc), "2.0.0")
+		return
+	}
+	req := q.Get("request")
+	if v := q.Get("version"); req != "GetCapabilities" && v != "" && v != "2.0.0" && v != "2.0.1" {
+		writeOWSException(w, owsError(owsInvalidParameter, "version", "Unsupported version %q, expecting 2.0.1", v), "2.0.0")
+		return
+	}
+	switch req {
+	case "GetCapabilities":
+		s.wcsCapabilities(w, r)
+	case "DescribeCoverage":
+		s.wcsDescribeCoverage(w, r, q)
+	case "GetCoverage":
+		cr, err := s.parseCoverage(q)
+		if err != nil {
+			writeOWSException(w, err, "2.0.0")
+			return
+		}
+		data, err := s.getCoverage(r, cr)
+		if err != nil {
+			log.Printf("Failed reading coverage %s: %v", r.URL.RawQuery, err)
+			writeOWSException(w, fmt.Errorf("Failed reading coverage"), "2.0.0")
+			return
+		}
+		w.Header().Set("Content-Type", "image/tiff")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", cr.d.Name+".tif"))
+		w.Write(data)
+		log.Printf("GetCoverage %s: %v", r.URL.RawQuery, time.Since(start))
+	case "":
+		writeOWSException(w, owsError(owsMissingParameter, "request", "Missing request parameter"), "2.0.0")
+	default:
+		writeOWSException(w, owsError(owsOperationNotSupported, "request",
+			"Unsupported request %q, expecting GetCapabilities, DescribeCoverage or GetCoverage", req), "2.0.0")
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prl900/earth_data_server/tiles"
+	"golang.org/x/image/tiff"
+)
+
+func TestWCSCapabilities(t *testing.T) {
+	s := newTestServer(t)
+	target := "/wcs?SERVICE=WCS&REQUEST=GetCapabilities"
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "text/xml")
+	var caps struct {
+		XMLName    xml.Name `xml:"http://www.opengis.net/wcs/2.0 Capabilities"`
+		Version    string   `xml:"version,attr"`
+		Profiles   []string `xml:"ServiceIdentification>Profile"`
+		Operations []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"OperationsMetadata>Operation"`
+		Formats   []string `xml:"ServiceMetadata>formatSupported"`
+		Coverages []struct {
+			Lower   string `xml:"WGS84BoundingBox>LowerCorner"`
+			Upper   string `xml:"WGS84BoundingBox>UpperCorner"`
+			ID      string `xml:"CoverageId"`
+			Subtype string `xml:"CoverageSubtype"`
+		} `xml:"Contents>CoverageSummary"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &caps); err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	if caps.Version != "2.0.1" || len(caps.Profiles) != 4 || len(caps.Operations) != 3 ||
+		!reflect.DeepEqual(caps.Formats, []string{"image/tiff"}) {
+		t.Errorf("GET %s: %+v", target, caps)
+	}
+	if len(caps.Coverages) != 2 {
+		t.Fatalf("GET %s: coverages %+v", target, caps.Coverages)
+	}
+	if c := caps.Coverages[0]; c.ID != "rgb" || c.Subtype != "RectifiedGridCoverage" || c.Lower != "-180 -90" || c.Upper != "180 90" {
+		t.Errorf("GET %s: coverage %+v", target, c)
+	}
+	if c := caps.Coverages[1]; c.ID != "series" || c.Subtype != "ReferenceableGridCoverage" || c.Lower != "-180 80" || c.Upper != "-160 90" {
+		t.Errorf("GET %s: coverage %+v", target, c)
+	}
+}
+
+// wcsDescription is a coverage description read back by the tests.
+type wcsDescription struct {
+	ID       string `xml:"CoverageId"`
+	Envelope struct {
+		SrsName    string `xml:"srsName,attr"`
+		AxisLabels string `xml:"axisLabels,attr"`
+		Dimension  int    `xml:"srsDimension,attr"`
+		Lower      string `xml:"lowerCorner"`
+		Upper      string `xml:"upperCorner"`
+	} `xml:"boundedBy>Envelope"`
+	Grid *struct {
+		High          string   `xml:"limits>GridEnvelope>high"`
+		Origin        string   `xml:"origin>Point>pos"`
+		OffsetVectors []string `xml:"offsetVector"`
+	} `xml:"domainSet>RectifiedGrid"`
+	TimeGrid *struct {
+		Dimension int    `xml:"dimension,attr"`
+		High      string `xml:"limits>GridEnvelope>high"`
+		Origin    string `xml:"origin>Point>pos"`
+		Axes      []struct {
+			OffsetVector string `xml:"GeneralGridAxis>offsetVector"`
+			Coefficients string `xml:"GeneralGridAxis>coefficients"`
+		} `xml:"generalGridAxis"`
+	} `xml:"domainSet>ReferenceableGridByVectors"`
+	Fields []struct {
+		Name   string `xml:"name,attr"`
+		NoData string `xml:"Quantity>nilValues>NilValues>nilValue"`
+	} `xml:"rangeType>DataRecord>field"`
+	Subtype string `xml:"ServiceParameters>CoverageSubtype"`
+}
+
+func TestWCSDescribeCoverage(t *testing.T) {
+	s := newTestServer(t)
+	target := "/wcs?SERVICE=WCS&VERSION=2.0.1&REQUEST=DescribeCoverage&COVERAGEID=rgb,series"
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "text/xml")
+	var descs struct {
+		XMLName      xml.Name         `xml:"http://www.opengis.net/wcs/2.0 CoverageDescriptions"`
+		Descriptions []wcsDescription `xml:"CoverageDescription"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &descs); err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	if len(descs.Descriptions) != 2 {
+		t.Fatalf("GET %s: %d descriptions, want 2", target, len(descs.Descriptions))
+	}
+
+	rgb := descs.Descriptions[0]
+	var fields []string
+	for _, f := range rgb.Fields {
+		fields = append(fields, f.Name+"="+f.NoData)
+	}
+	if rgb.ID != "rgb" || rgb.Subtype != "RectifiedGridCoverage" || rgb.TimeGrid != nil ||
+		!reflect.DeepEqual(fields, []string{"red=255", "green=255", "blue=255"}) {
+		t.Errorf("GET %s: rgb coverage %+v", target, rgb)
+	}
+	if e := rgb.Envelope; e.SrsName != crs4326 || e.AxisLabels != "Lat Long" || e.Dimension != 2 || e.Lower != "-90 -180" || e.Upper != "90 180" {
+		t.Errorf("GET %s: rgb envelope %+v", target, e)
+	}
+	if g := rgb.Grid; g == nil || g.High != "44 89" || g.Origin != "88 -178" || !reflect.DeepEqual(g.OffsetVectors, []string{"-4 0", "0 4"}) {
+		t.Errorf("GET %s: rgb grid %+v", target, g)
+	}
+
+	// The dates of the series are the coefficients of its time axis
+	series := descs.Descriptions[1]
+	if series.ID != "series" || series.Subtype != "ReferenceableGridCoverage" || series.Grid != nil {
+		t.Errorf("GET %s: series coverage %+v", target, series)
+	}
+	if e := series.Envelope; e.SrsName != crs4326Time || e.AxisLabels != "Lat Long ansi" || e.Dimension != 3 ||
+		e.Lower != `80 -180 "2004-01-01"` || e.Upper != `90 -160 "2004-03-01"` {
+		t.Errorf("GET %s: series envelope %+v", target, e)
+	}
+	g := series.TimeGrid
+	if g == nil || g.Dimension != 3 || g.High != "9 19 2" || g.Origin != `89.5 -179.5 "2004-01-01"` || len(g.Axes) != 3 {
+		t.Fatalf("GET %s: series grid %+v", target, g)
+	}
+	if a := g.Axes[2]; a.OffsetVector != "0 0 1" || a.Coefficients != `"2004-01-01" "2004-02-01" "2004-03-01"` {
+		t.Errorf("GET %s: series time axis %+v", target, a)
+	}
+	if a := g.Axes[0]; a.OffsetVector != "-1 0 0" || a.Coefficients != "" {
+		t.Errorf("GET %s: series latitude axis %+v", target, a)
+	}
+}
+
+func TestWCSGetCoverage(t *testing.T) {
+	s := newTestServer(t)
+	getCoverage := "/wcs?SERVICE=WCS&VERSION=2.0.1&REQUEST=GetCoverage&FORMAT=image/tiff"
+	for _, c := range []struct {
+		query         string
+		width, height int
+	}{
+		{"&COVERAGEID=rgb&RANGESUBSET=blue", 90, 45},
+		{"&COVERAGEID=rgb&SUBSET=Lat(-22,22)&SUBSET=Long(0,40)&RANGESUBSET=green", 10, 11},
+		// Partially covered pixels are included
+		{"&COVERAGEID=rgb&SUBSET=Lat(-21,21)&SUBSET=Long(1,39)&RANGESUBSET=red", 10, 11},
+		{"&COVERAGEID=rgb&SUBSET=Lat(*,0)&SUBSET=Long(170,*)&RANGESUBSET=red", 3, 23},
+		{"&COVERAGEID=series&SUBSET=Lat(85,90)&SUBSET=time(\"2004-02-01\")", 20, 5},
+		{"&coverageId=series&subset=Long(-170,-165)&subset=ansi(\"2004-02-10\")", 5, 10},
+	} {
+		target := getCoverage + c.query
+		w := get(s, target)
+		checkResponse(t, target, w, http.StatusOK, "image/tiff")
+		cfg, err := tiff.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		if cfg.Width != c.width || cfg.Height != c.height {
+			t.Errorf("GET %s: %dx%d coverage, want %dx%d", target, cfg.Width, cfg.Height, c.width, c.height)
+		}
+	}
+
+	target := getCoverage + "&COVERAGEID=rgb&RANGESUBSET=red:green,blue"
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "image/tiff")
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="rgb.tif"` {
+		t.Errorf("GET %s: content disposition %q", target, cd)
+	}
+	if !bytes.HasPrefix(w.Body.Bytes(), []byte("II*\x00")) {
+		t.Errorf("GET %s: not a TIFF file", target)
+	}
+}
+
+func TestWCSErrors(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxPixels = 2 * 90 * 45
+	getCoverage := "/wcs?SERVICE=WCS&VERSION=2.0.1&REQUEST=GetCoverage&COVERAGEID=rgb"
+	for _, c := range []struct {
+		target  string
+		status  int
+		code    string
+		locator string
+	}{
+		{"/wcs?SERVICE=WMS&REQUEST=GetCapabilities", http.StatusBadRequest, owsInvalidParameter, "service"},
+		{"/wcs?SERVICE=WCS&VERSION=1.0.0&REQUEST=DescribeCoverage&COVERAGEID=rgb", http.StatusBadRequest, owsInvalidParameter, "version"},
+		{"/wcs?SERVICE=WCS", http.StatusBadRequest, owsMissingParameter, "request"},
+		{"/wcs?SERVICE=WCS&REQUEST=GetMap", http.StatusNotImplemented, owsOperationNotSupported, "request"},
+		{"/wcs?SERVICE=WCS&REQUEST=DescribeCoverage", http.StatusBadRequest, owsMissingParameter, "coverageId"},
+		{"/wcs?SERVICE=WCS&REQUEST=DescribeCoverage&COVERAGEID=rgb,nowhere", http.StatusNotFound, wcsNoSuchCoverage, "coverageId"},
+		{"/wcs?SERVICE=WCS&REQUEST=GetCoverage&COVERAGEID=rgb,series", http.StatusBadRequest, owsInvalidParameter, "coverageId"},
+		{getCoverage + "&FORMAT=image/png", http.StatusBadRequest, owsInvalidParameter, "format"},
+		{getCoverage + "&SUBSETTINGCRS=http://www.opengis.net/def/crs/EPSG/0/3857", http.StatusBadRequest, owsInvalidParameter, "subsettingCrs"},
+		{getCoverage + "&SUBSET=Lat(10)", http.StatusBadRequest, wcsInvalidSubsetting, "subset"},
+		{getCoverage + "&SUBSET=Lat(10,x)", http.StatusBadRequest, wcsInvalidSubsetting, "subset"},
+		{getCoverage + "&SUBSET=Lat(95,99)", http.StatusBadRequest, wcsInvalidSubsetting, "subset"},
+		{getCoverage + "&SUBSET=Lat[0,10]", http.StatusBadRequest, wcsInvalidSubsetting, "subset"},
+		{getCoverage + "&SUBSET=Height(0,10)", http.StatusBadRequest, wcsInvalidAxisLabel, "subset"},
+		{getCoverage + "&SUBSET=time(\"2004-01-01\")", http.StatusBadRequest, wcsInvalidSubsetting, "subset"},
+		{"/wcs?SERVICE=WCS&REQUEST=GetCoverage&COVERAGEID=series&SUBSET=time(\"2004-01-01\",\"2004-02-01\")",
+			http.StatusBadRequest, wcsInvalidSubsetting, "subset"},
+		{getCoverage + "&RANGESUBSET=purple", http.StatusBadRequest, wcsNoSuchField, "rangeSubset"},
+		{getCoverage + "&RANGESUBSET=blue:red", http.StatusBadRequest, wcsNoSuchField, "rangeSubset"},
+		{getCoverage, http.StatusBadRequest, owsInvalidParameter, "subset"},
+	} {
+		w := get(s, c.target)
+		checkResponse(t, c.target, w, c.status, "text/xml")
+		var report struct {
+			XMLName   xml.Name `xml:"http://www.opengis.net/ows/2.0 ExceptionReport"`
+			Version   string   `xml:"version,attr"`
+			Exception owsException
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("GET %s: %v", c.target, err)
+		}
+		if e := report.Exception; report.Version != "2.0.0" || e.Code != c.code || e.Locator != c.locator || e.Text == "" {
+			t.Errorf("GET %s: exception %+v, want %s at %s", c.target, e, c.code, c.locator)
+		}
+	}
+	target := getCoverage + "&RANGESUBSET=red,green"
+	checkResponse(t, target, get(s, target), http.StatusOK, "image/tiff")
+}
+
+func TestParseSubset(t *testing.T) {
+	for _, c := range []struct {
+		subset          string
+		axis, low, high string
+	}{
+		{"Lat(36,44)", "Lat", "36", "44"},
+		{"Long(*,4.5)", "Long", "*", "4.5"},
+		{`time("2004-02-01")`, "time", "2004-02-01", ""},
+		{`ansi("2004-01-01","2004-03-01")`, "ansi", "2004-01-01", "2004-03-01"},
+	} {
+		axis, low, high, err := parseSubset(c.subset)
+		if err != nil || axis != c.axis || low != c.low || high != c.high {
+			t.Errorf("parseSubset(%q) = %q, %q, %q, %v, want %q, %q, %q", c.subset, axis, low, high, err, c.axis, c.low, c.high)
+		}
+	}
+	for _, subset := range []string{"Lat", "Lat(36,44", "Lat36,44)", "Lat(1,2,3)"} {
+		if _, _, _, err := parseSubset(subset); err == nil {
+			t.Errorf("parseSubset(%q) succeeded", subset)
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	for _, c := range []struct {
+		low, high string
+		lo, hi    float64
+	}{
+		{"36", "44", 36, 44},
+		{"*", "44", -90, 44},
+		{"36", "*", 36, 90},
+		{"*", "*", -90, 90},
+		// Bounds beyond the coverage are clamped
+		{"-100", "100", -90, 90},
+	} {
+		lo, hi := -90., 90.
+		if err := trim("Lat", c.low, c.high, &lo, &hi); err != nil || lo != c.lo || hi != c.hi {
+			t.Errorf("Trimming [-90, 90] to %s, %s: %v, %v, %v, want %v, %v", c.low, c.high, lo, hi, err, c.lo, c.hi)
+		}
+	}
+	for _, c := range []struct{ low, high string }{
+		{"36", ""}, {"x", "44"}, {"36", "NaN"}, {"-Inf", "44"}, {"44", "36"}, {"40", "40"}, {"95", "99"},
+	} {
+		lo, hi := -90., 90.
+		if err := trim("Lat", c.low, c.high, &lo, &hi); err == nil {
+			t.Errorf("Trimming [-90, 90] to %s, %s succeeded", c.low, c.high)
+		}
+	}
+}
+
+func TestParseRangeSubset(t *testing.T) {
+	m := &tiles.Manifest{Bands: []string{"red", "green", "blue", "nir"}}
+	for _, c := range []struct {
+		rs   string
+		want []int
+	}{
+		{"", []int{0, 1, 2, 3}},
+		{"green", []int{1}},
+		{"blue,red", []int{2, 0}},
+		{"red:blue", []int{0, 1, 2}},
+		{"nir,green:blue", []int{3, 1, 2}},
+		{"green:green", []int{1}},
+	} {
+		if got, err := parseRangeSubset(m, c.rs); err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseRangeSubset(%q) = %v, %v, want %v", c.rs, got, err, c.want)
+		}
+	}
+	for _, rs := range []string{"purple", "red,", "blue:red", "red:purple", ":red", strings.Repeat("red:", 2)} {
+		if got, err := parseRangeSubset(m, rs); err == nil {
+			t.Errorf("parseRangeSubset(%q) = %v, want an error", rs, got)
+		}
+	}
+}
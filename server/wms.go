@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prl900/earth_data_server/tiles"
+)
+
+// WMS 1.3.0 exception codes
+const (
+	wmsInvalidFormat         = "InvalidFormat"
+	wmsInvalidCRS            = "InvalidCRS"
+	wmsLayerNotDefined       = "LayerNotDefined"
+	wmsInvalidDimension      = "InvalidDimensionValue"
+	wmsMissingParameter      = "MissingParameterValue"
+	wmsOperationNotSupported = "OperationNotSupported"
+	wmsNoApplicableCode      = "NoApplicableCode"
+)
+
+// wmsException is an error reported to WMS clients as a service exception.
+type wmsException struct {
+	Code    string `xml:"code,attr,omitempty"`
+	Message string `xml:",chardata"`
+}
+
+func (e *wmsException) Error() string {
+	return e.Message
+}
+
+func wmsError(code, format string, args ...interface{}) error {
+	return &wmsException{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// writeWMSException writes err as a WMS service exception report.
+func writeWMSException(w http.ResponseWriter, err error, status int) {
+	e, ok := err.(*wmsException)
+	if !ok {
+		e = &wmsException{Code: wmsNoApplicableCode, Message: err.Error()}
+	}
+	report := struct {
+		XMLName   xml.Name      `xml:"ServiceExceptionReport"`
+		Version   string        `xml:"version,attr"`
+		Xmlns     string        `xml:"xmlns,attr"`
+		Exception *wmsException `xml:"ServiceException"`
+	}{Version: "1.3.0", Xmlns: "http://www.opengis.net/ogc", Exception: e}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(report)
+}
+
+type wmsOperation struct {
+	Format         []string
+	OnlineResource onlineResource `xml:"DCPType>HTTP>Get>OnlineResource"`
+}
+
+type wmsGeoBBox struct {
+	West  float64 `xml:"westBoundLongitude"`
+	East  float64 `xml:"eastBoundLongitude"`
+	South float64 `xml:"southBoundLatitude"`
+	North float64 `xml:"northBoundLatitude"`
+}
+
+type wmsBBox struct {
+	CRS  string  `xml:"CRS,attr"`
+	MinX float64 `xml:"minx,attr"`
+	MinY float64 `xml:"miny,attr"`
+	MaxX float64 `xml:"maxx,attr"`
+	MaxY float64 `xml:"maxy,attr"`
+}
+
+type wmsDimension struct {
+	Name    string `xml:"name,attr"`
+	Units   string `xml:"units,attr"`
+	Default string `xml:"default,attr"`
+	Nearest int    `xml:"nearestValue,attr"`
+	Values  string `xml:",chardata"`
+}
+
+type wmsLayer struct {
+	Name      string `xml:",omitempty"`
+	Title     string
+	CRS       []string
+	GeoBBox   *wmsGeoBBox `xml:"EX_GeographicBoundingBox"`
+	BBox      []wmsBBox   `xml:"BoundingBox"`
+	Dimension *wmsDimension
+	Layers    []wmsLayer `xml:"Layer"`
+}
+
+type wmsCapabilities struct {
+	XMLName xml.Name `xml:"WMS_Capabilities"`
+	Version string   `xml:"version,attr"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	XLink   string   `xml:"xmlns:xlink,attr"`
+	Service struct {
+		Name           string
+		Title          string
+		OnlineResource onlineResource
+	}
+	Capability struct {
+		GetCapabilities wmsOperation `xml:"Request>GetCapabilities"`
+		GetMap          wmsOperation `xml:"Request>GetMap"`
+		Exception       []string     `xml:"Exception>Format"`
+		Layer           wmsLayer
+	}
+}
+
+// wmsLayerOf describes l and, for datasets, its band layers in the
+// capabilities.
+func wmsLayerOf(l layer, bands []layer) wmsLayer {
+	m := l.d.Manifest
+	b := m.BBox()
+	wl := wmsLayer{Name: l.Name, Title: l.Title,
+		GeoBBox: &wmsGeoBBox{West: b.MinLon, East: b.MaxLon, South: b.MinLat, North: b.MaxLat},
+		BBox: []wmsBBox{
+			{CRS: "CRS:84", MinX: b.MinLon, MinY: b.MinLat, MaxX: b.MaxLon, MaxY: b.MaxLat},
+			// EPSG:4326 has latitude first
+			{CRS: "EPSG:4326", MinX: b.MinLat, MinY: b.MinLon, MaxX: b.MaxLat, MaxY: b.MaxLon},
+		},
+	}
+	if len(m.Times) > 0 {
+		wl.Dimension = &wmsDimension{Name: "time", Units: "ISO8601", Default: m.Times[len(m.Times)-1],
+			Nearest: 1, Values: strings.Join(m.Times, ",")}
+	}
+	for _, bl := range bands {
+		wl.Layers = append(wl.Layers, wmsLayer{Name: bl.Name, Title: bl.Title})
+	}
+	return wl
+}
+
+func (s *Server) wmsCapabilities(w http.ResponseWriter, r *http.Request) {
+	caps := wmsCapabilities{Version: "1.3.0", Xmlns: "http://www.opengis.net/wms", XLink: "http://www.w3.org/1999/xlink"}
+	href := serviceURL(r)
+	caps.Service.Name = "WMS"
+	caps.Service.Title = "Earth data server"
+	caps.Service.OnlineResource = link(href)
+	caps.Capability.GetCapabilities = wmsOperation{Format: []string{"text/xml"}, OnlineResource: link(href)}
+	caps.Capability.GetMap = wmsOperation{Format: []string{"image/png"}, OnlineResource: link(href)}
+	caps.Capability.Exception = []string{"XML"}
+	root := wmsLayer{Title: "Earth data server", CRS: []string{"EPSG:4326", "CRS:84"},
+		GeoBBox: &wmsGeoBBox{West: -180, East: 180, South: -90, North: 90}}
+	for _, d := range s.sortedDatasets() {
+		ls := datasetLayers(d)
+		root.Layers = append(root.Layers, wmsLayerOf(ls[0], ls[1:]))
+	}
+	caps.Capability.Layer = root
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(caps)
+}
+
+// wmsBBoxParam parses the BBOX parameter in the axis order of crs.
+func wmsBBoxParam(q url.Values, crs string) (tiles.BBox, error) {
+	parts := strings.Split(q.Get("bbox"), ",")
+	if len(parts) != 4 {
+		return tiles.BBox{}, wmsError("", "Invalid BBOX parameter: %q", q.Get("bbox"))
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return tiles.BBox{}, wmsError("", "Invalid BBOX parameter: %q", q.Get("bbox"))
+		}
+		v[i] = f
+	}
+	b := tiles.BBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if crs == "EPSG:4326" {
+		b = tiles.BBox{MinLat: v[0], MinLon: v[1], MaxLat: v[2], MaxLon: v[3]}
+	}
+	if err := b.Validate(); err != nil {
+		return b, wmsError("", "%v", err)
+	}
+	return b, nil
+}
+
+// wmsSize parses the WIDTH and HEIGHT parameters.
+func (s *Server) wmsSize(q url.Values) (int, int, error) {
+	var size [2]int
+	for i, name := range []string{"width", "height"} {
+		v, err := strconv.Atoi(q.Get(name))
+		if err != nil || v <= 0 {
+			return 0, 0, wmsError("", "Invalid %s parameter: %q", strings.ToUpper(name), q.Get(name))
+		}
+		size[i] = v
+	}
+	if !fitsPixels(size[0], size[1], 1, s.MaxPixels) {
+		return 0, 0, wmsError("", "Map too large: %dx%d pixels", size[0], size[1])
+	}
+	return size[0], size[1], nil
+}
+
+// getMap renders the LAYERS of a GetMap request, drawn in order.
+func (s *Server) getMap(r *http.Request, q url.Values) (image.Image, error) {
+	if q.Get("layers") == "" {
+		return nil, wmsError(wmsMissingParameter, "Missing LAYERS parameter")
+	}
+	var ls []layer
+	for _, name := range strings.Split(q.Get("layers"), ",") {
+		l, ok := s.layer(name)
+		if !ok {
+			return nil, wmsError(wmsLayerNotDefined, "Unknown layer: %q", name)
+		}
+		ls = append(ls, l)
+	}
+	if f := q.Get("format"); f != "image/png" {
+		return nil, wmsError(wmsInvalidFormat, "Unsupported FORMAT %q, expecting image/png", f)
+	}
+	crs := strings.ToUpper(q.Get("crs"))
+	if crs != "EPSG:4326" && crs != "CRS:84" {
+		return nil, wmsError(wmsInvalidCRS, "Unsupported CRS %q, expecting EPSG:4326 or CRS:84", q.Get("crs"))
+	}
+	bbox, err := wmsBBoxParam(q, crs)
+	if err != nil {
+		return nil, err
+	}
+	width, height, err := s.wmsSize(q)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range ls {
+		if level, rect := l.d.Manifest.BBoxSource(bbox, width, height); !fitsPixels(rect.Dx(), rect.Dy(), len(l.bands), s.MaxPixels) {
+			return nil, wmsError("", "Map too large: reading %dx%d pixels of level %d of %s", rect.Dx(), rect.Dy(), level, l.Name)
+		}
+	}
+	transparent := strings.ToUpper(q.Get("transparent")) == "TRUE"
+	method := q.Get("resampling")
+	if method == "" {
+		method = "nearest"
+	}
+	if !validMethod(method) {
+		return nil, wmsError("", "Unknown resampling method %q, expecting one of: %s",
+			method, strings.Join(tiles.ResampleMethods(), ", "))
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for _, l := range ls {
+		m := l.d.Manifest
+		t, err := timeIndex(m, q.Get("time"), true)
+		if err != nil {
+			return nil, wmsError(wmsInvalidDimension, "%v", err)
+		}
+		bands, err := s.render(l, t, func(m *tiles.Manifest, read tiles.TileReader) (*image.Gray, error) {
+			return m.MosaicBBoxSize(r.Context(), bbox, width, height, method, read)
+		})
+		if err != nil {
+			return nil, err
+		}
+		draw.Draw(canvas, canvas.Bounds(), compose(bands, m.NoData, transparent), image.ZP, draw.Over)
+	}
+	return canvas, nil
+}
+
+// wms serves WMS 1.3.0 GetCapabilities and GetMap requests.
+func (s *Server) wms(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	q := ogcQuery(r)
+	if svc := q.Get("service"); svc != "" && strings.ToUpper(svc) != "WMS" {
+		writeWMSException(w, wmsError("", "Unsupported SERVICE %q, expecting WMS", svc), http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("version"); v != "" && v != "1.3.0" {
+		writeWMSException(w, wmsError("", "Unsupported VERSION %q, expecting 1.3.0", v), http.StatusBadRequest)
+		return
+	}
+
+	switch req := q.Get("request"); req {
+	case "GetCapabilities":
+		s.wmsCapabilities(w, r)
+	case "GetMap":
+		im, err := s.getMap(r, q)
+		if _, ok := err.(*wmsException); ok {
+			writeWMSException(w, err, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed rendering map %s: %v", r.URL.RawQuery, err)
+			writeWMSException(w, fmt.Errorf("Failed rendering map"), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, im); err != nil {
+			writeWMSException(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		buf.WriteTo(w)
+		log.Printf("GetMap %s: %v", r.URL.RawQuery, time.Since(start))
+	default:
+		writeWMSException(w, wmsError(wmsOperationNotSupported, "Unsupported REQUEST %q, expecting GetCapabilities or GetMap", req),
+			http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/xml"
+	"image/color"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// wmsCapsLayer is a layer of the WMS capabilities read back by the tests.
+type wmsCapsLayer struct {
+	Name      string
+	Title     string
+	CRS       []string
+	BBox      []wmsBBox `xml:"BoundingBox"`
+	Dimension *struct {
+		Name    string `xml:"name,attr"`
+		Default string `xml:"default,attr"`
+		Values  string `xml:",chardata"`
+	}
+	Layers []wmsCapsLayer `xml:"Layer"`
+}
+
+func TestWMSCapabilities(t *testing.T) {
+	s := newTestServer(t)
+	target := "/wms?SERVICE=WMS&REQUEST=GetCapabilities"
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "text/xml")
+	var caps struct {
+		XMLName xml.Name     `xml:"http://www.opengis.net/wms WMS_Capabilities"`
+		Version string       `xml:"version,attr"`
+		Formats []string     `xml:"Capability>Request>GetMap>Format"`
+		Layer   wmsCapsLayer `xml:"Capability>Layer"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &caps); err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	if caps.Version != "1.3.0" || !reflect.DeepEqual(caps.Formats, []string{"image/png"}) {
+		t.Errorf("GET %s: version %s, formats %v", target, caps.Version, caps.Formats)
+	}
+	if !strings.Contains(w.Body.String(), `xlink:href="http://example.com/wms?"`) {
+		t.Errorf("GET %s: no link to the service", target)
+	}
+
+	root := caps.Layer
+	if root.Name != "" || !reflect.DeepEqual(root.CRS, []string{"EPSG:4326", "CRS:84"}) || len(root.Layers) != 2 {
+		t.Fatalf("GET %s: root layer %+v", target, root)
+	}
+	rgb, series := root.Layers[0], root.Layers[1]
+	var bands []string
+	for _, l := range rgb.Layers {
+		bands = append(bands, l.Name)
+	}
+	if rgb.Name != "rgb" || !reflect.DeepEqual(bands, []string{"rgb:red", "rgb:green", "rgb:blue"}) || rgb.Dimension != nil {
+		t.Errorf("GET %s: rgb layer %+v", target, rgb)
+	}
+	want := []wmsBBox{{CRS: "CRS:84", MinX: -180, MinY: 80, MaxX: -160, MaxY: 90},
+		{CRS: "EPSG:4326", MinX: 80, MinY: -180, MaxX: 90, MaxY: -160}}
+	if series.Name != "series" || !reflect.DeepEqual(series.BBox, want) {
+		t.Errorf("GET %s: series layer %+v, want bounding boxes %+v", target, series, want)
+	}
+	if d := series.Dimension; d == nil || d.Name != "time" || d.Default != "2004-03-01" || d.Values != strings.Join(seriesTimes, ",") {
+		t.Errorf("GET %s: series time dimension %+v", target, d)
+	}
+}
+
+func TestWMSGetMap(t *testing.T) {
+	s := newTestServer(t)
+	for _, c := range []struct {
+		query string
+		band  int
+	}{
+		{"LAYERS=rgb:red&CRS=CRS:84&BBOX=0,-22,40,22", 0},
+		// EPSG:4326 has latitude first
+		{"LAYERS=rgb:blue&CRS=EPSG:4326&BBOX=-22,0,22,40", 2},
+		// Parameter names are case insensitive
+		{"layers=rgb:green&crs=CRS:84&bbox=0,-22,40,22", 1},
+		// The composite of the three bands
+		{"LAYERS=rgb&CRS=CRS:84&BBOX=0,-22,40,22", -1},
+	} {
+		target := "/wms?SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&STYLES=&FORMAT=image/png&WIDTH=10&HEIGHT=11&" + c.query
+		im := decodePNG(t, target, get(s, target))
+		if b := im.Bounds(); b.Dx() != 10 || b.Dy() != 11 {
+			t.Fatalf("GET %s: %dx%d map, want 10x11", target, b.Dx(), b.Dy())
+		}
+		for y := 0; y < 11; y++ {
+			for x := 0; x < 10; x++ {
+				v := func(b int) uint8 { return rgbValue(45+x, 17+y, b) }
+				want := color.NRGBA{v(0), v(1), v(2), 255}
+				if c.band >= 0 {
+					want = color.NRGBA{v(c.band), v(c.band), v(c.band), 255}
+				}
+				if got := color.NRGBAModel.Convert(im.At(x, y)); got != want {
+					t.Fatalf("GET %s: pixel %d, %d is %v, want %v", target, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestWMSGetMapTime(t *testing.T) {
+	s := newTestServer(t)
+	for _, c := range []struct {
+		query string
+		t     int
+	}{
+		{"", 2},
+		{"&TIME=2004-02-01", 1},
+		// The nearest date
+		{"&TIME=2004-01-15", 0},
+	} {
+		// Pixels beyond the dataset are nodata, transparent
+		target := "/wms?SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&LAYERS=series&STYLES=&CRS=CRS:84&BBOX=-180,70,-150,90" +
+			"&WIDTH=30&HEIGHT=20&FORMAT=image/png&TRANSPARENT=TRUE" + c.query
+		im := decodePNG(t, target, get(s, target))
+		v := seriesValue(3, 4, c.t)
+		if got, want := color.NRGBAModel.Convert(im.At(3, 4)), (color.NRGBA{v, v, v, 255}); got != want {
+			t.Errorf("GET %s: pixel 3, 4 is %v, want %v", target, got, want)
+		}
+		if got := color.NRGBAModel.Convert(im.At(25, 15)); got != (color.NRGBA{}) {
+			t.Errorf("GET %s: pixel 25, 15 is %v, want transparent", target, got)
+		}
+	}
+}
+
+func TestWMSErrors(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxPixels = 100 * 100
+	addFlat(s)
+	getMap := "/wms?SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&STYLES="
+	valid := "&LAYERS=rgb&CRS=CRS:84&BBOX=0,-22,40,22&WIDTH=10&HEIGHT=11&FORMAT=image/png"
+	for _, c := range []struct {
+		target string
+		code   string
+	}{
+		{"/wms?SERVICE=WFS&REQUEST=GetCapabilities", ""},
+		{"/wms?SERVICE=WMS&VERSION=1.1.1&REQUEST=GetMap" + valid, ""},
+		{"/wms?SERVICE=WMS&REQUEST=GetFeatureInfo", wmsOperationNotSupported},
+		{"/wms", wmsOperationNotSupported},
+		{getMap + "&CRS=CRS:84&BBOX=0,-22,40,22&WIDTH=10&HEIGHT=11&FORMAT=image/png", wmsMissingParameter},
+		{getMap + strings.Replace(valid, "LAYERS=rgb", "LAYERS=rgb,nowhere", 1), wmsLayerNotDefined},
+		{getMap + strings.Replace(valid, "image/png", "image/gif", 1), wmsInvalidFormat},
+		{getMap + strings.Replace(valid, "CRS:84", "EPSG:3857", 1), wmsInvalidCRS},
+		{getMap + strings.Replace(valid, "0,-22,40,22", "0,-22,40", 1), ""},
+		{getMap + strings.Replace(valid, "0,-22,40,22", "40,-22,0,22", 1), ""},
+		{getMap + strings.Replace(valid, "WIDTH=10", "WIDTH=-10", 1), ""},
+		{getMap + strings.Replace(valid, "WIDTH=10&HEIGHT=11", "WIDTH=101&HEIGHT=100", 1), ""},
+		{getMap + valid + "&RESAMPLING=sinc", ""},
+		{getMap + valid + "&TIME=2004-01-01", wmsInvalidDimension},
+		{getMap + strings.Replace(valid, "LAYERS=rgb", "LAYERS=series", 1) + "&TIME=January", wmsInvalidDimension},
+		// Three bands of 90x45 pixels read without overviews
+		{getMap + strings.NewReplacer("LAYERS=rgb", "LAYERS=flat", "0,-22,40,22", "-180,-90,180,90").Replace(valid), ""},
+	} {
+		w := get(s, c.target)
+		checkResponse(t, c.target, w, http.StatusBadRequest, "text/xml")
+		var report struct {
+			XMLName   xml.Name `xml:"http://www.opengis.net/ogc ServiceExceptionReport"`
+			Exception struct {
+				Code    string `xml:"code,attr"`
+				Message string `xml:",chardata"`
+			} `xml:"ServiceException"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("GET %s: %v", c.target, err)
+		}
+		if report.Exception.Code != c.code || report.Exception.Message == "" {
+			t.Errorf("GET %s: exception %+v, want code %q", c.target, report.Exception, c.code)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prl900/earth_data_server/tiles"
+	"golang.org/x/net/context"
+)
+
+// metresPerDegree is the length of a degree along the equator in the
+// WGS84 ellipsoid, used by WMTS to compute the scale of EPSG:4326 tiles.
+const metresPerDegree = 6378137 * 2 * math.Pi / 360
+
+// wmtsFormats maps the formats of the tiles to their extensions.
+var wmtsFormats = map[string]string{"image/png": "png", "image/jpeg": "jpg"}
+
+type wmtsDimension struct {
+	Identifier string   `xml:"ows:Identifier"`
+	Default    string   `xml:"Default"`
+	Values     []string `xml:"Value"`
+}
+
+type wmtsResourceURL struct {
+	Format       string `xml:"format,attr"`
+	ResourceType string `xml:"resourceType,attr"`
+	Template     string `xml:"template,attr"`
+}
+
+type wmtsLayer struct {
+	Title         string            `xml:"ows:Title"`
+	WGS84BBox     owsBBox           `xml:"ows:WGS84BoundingBox"`
+	Identifier    string            `xml:"ows:Identifier"`
+	Style         string            `xml:"Style>ows:Identifier"`
+	Formats       []string          `xml:"Format"`
+	Dimension     *wmtsDimension    `xml:"Dimension"`
+	TileMatrixSet string            `xml:"TileMatrixSetLink>TileMatrixSet"`
+	ResourceURLs  []wmtsResourceURL `xml:"ResourceURL"`
+}
+
+type wmtsTileMatrix struct {
+	Identifier       string  `xml:"ows:Identifier"`
+	ScaleDenominator float64 `xml:"ScaleDenominator"`
+	TopLeftCorner    string  `xml:"TopLeftCorner"`
+	TileWidth        int     `xml:"TileWidth"`
+	TileHeight       int     `xml:"TileHeight"`
+	MatrixWidth      int     `xml:"MatrixWidth"`
+	MatrixHeight     int     `xml:"MatrixHeight"`
+}
+
+type wmtsTileMatrixSet struct {
+	Identifier   string           `xml:"ows:Identifier"`
+	SupportedCRS string           `xml:"ows:SupportedCRS"`
+	TileMatrices []wmtsTileMatrix `xml:"TileMatrix"`
+}
+
+type wmtsCapabilities struct {
+	XMLName    xml.Name            `xml:"Capabilities"`
+	Xmlns      string              `xml:"xmlns,attr"`
+	Ows        string              `xml:"xmlns:ows,attr"`
+	XLink      string              `xml:"xmlns:xlink,attr"`
+	Version    string              `xml:"version,attr"`
+	Title      string              `xml:"ows:ServiceIdentification>ows:Title"`
+	Type       string              `xml:"ows:ServiceIdentification>ows:ServiceType"`
+	TypeVer    string              `xml:"ows:ServiceIdentification>ows:ServiceTypeVersion"`
+	Operations []owsOperation      `xml:"ows:OperationsMetadata>ows:Operation"`
+	Layers     []wmtsLayer         `xml:"Contents>Layer"`
+	Sets       []wmtsTileMatrixSet `xml:"Contents>TileMatrixSet"`
+	Metadata   xlinkHref           `xml:"ServiceMetadataURL"`
+}
+
+// tileMatrixSet describes the native tile grid of d, named after it as
+// datasets may share a manifest: one tile matrix per overview level, from
+// the coarsest one, identified as 0, to the full resolution.
+func tileMatrixSet(d *tiles.Dataset) wmtsTileMatrixSet {
+	m := d.Manifest
+	set := wmtsTileMatrixSet{Identifier: d.Name, SupportedCRS: "urn:ogc:def:crs:EPSG::4326"}
+	g := m.Grid()
+	for i := 0; i < m.Levels; i++ {
+		level := m.Levels - 1 - i
+		w, h := m.LevelSize(level)
+		set.TileMatrices = append(set.TileMatrices, wmtsTileMatrix{
+			Identifier:       strconv.Itoa(i),
+			ScaleDenominator: m.LevelGrid(level).PixelWidth * metresPerDegree / 0.28e-3,
+			// EPSG:4326 has latitude first
+			TopLeftCorner: fmt.Sprintf("%v %v", g.OriginY, g.OriginX),
+			TileWidth:     m.TileSize,
+			TileHeight:    m.TileSize,
+			MatrixWidth:   (w + m.TileSize - 1) / m.TileSize,
+			MatrixHeight:  (h + m.TileSize - 1) / m.TileSize,
+		})
+	}
+	return set
+}
+
+// wmtsLayerOf describes l in the capabilities, with the template of the
+// RESTful URLs of its tiles under base.
+func wmtsLayerOf(l layer, base string) wmtsLayer {
+	m := l.d.Manifest
+	b := m.BBox()
+	wl := wmtsLayer{Title: l.Title, Identifier: l.Name, Style: "default", TileMatrixSet: l.d.Name,
+		WGS84BBox: owsBBox{Lower: fmt.Sprintf("%v %v", b.MinLon, b.MinLat), Upper: fmt.Sprintf("%v %v", b.MaxLon, b.MaxLat)}}
+	dims := ""
+	if len(m.Times) > 0 {
+		wl.Dimension = &wmtsDimension{Identifier: "Time", Default: m.Times[len(m.Times)-1], Values: m.Times}
+		dims = "{Time}/"
+	}
+	for _, f := range []string{"image/png", "image/jpeg"} {
+		wl.Formats = append(wl.Formats, f)
+		wl.ResourceURLs = append(wl.ResourceURLs, wmtsResourceURL{Format: f, ResourceType: "tile",
+			Template: base + "/" + url.PathEscape(l.Name) + "/{Style}/" + dims + "{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}." + wmtsFormats[f]})
+	}
+	return wl
+}
+
+func (s *Server) wmtsCapabilities(w http.ResponseWriter, r *http.Request) {
+	kvp := baseURL(r) + "/wmts?"
+	rest := baseURL(r) + "/wmts/1.0.0"
+	caps := wmtsCapabilities{Xmlns: "http://www.opengis.net/wmts/1.0", Ows: "http://www.opengis.net/ows/1.1",
+		XLink: "http://www.w3.org/1999/xlink", Version: "1.0.0",
+		Title: "Earth data server", Type: "OGC WMTS", TypeVer: "1.0.0",
+		Operations: []owsOperation{{Name: "GetCapabilities", Get: xlinkHref{kvp}}, {Name: "GetTile", Get: xlinkHref{kvp}}},
+		Metadata:   xlinkHref{rest + "/WMTSCapabilities.xml"}}
+	for _, d := range s.sortedDatasets() {
+		for _, l := range datasetLayers(d) {
+			caps.Layers = append(caps.Layers, wmtsLayerOf(l, rest))
+		}
+		caps.Sets = append(caps.Sets, tileMatrixSet(d))
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(caps)
+}
+
+// tileRequest holds the parameters of a GetTile request.
+type tileRequest struct {
+	layer, style, set, matrix, row, col, format, time string
+}
+
+// getTile returns a stored tile of a layer, decoded and composed in color
+// or gray, without resampling.
+func (s *Server) getTile(ctx context.Context, req tileRequest) (image.Image, error) {
+	l, ok := s.layer(req.layer)
+	if !ok {
+		return nil, owsError(owsInvalidParameter, "layer", "Unknown layer: %q", req.layer)
+	}
+	m := l.d.Manifest
+	if req.style != "" && req.style != "default" {
+		return nil, owsError(owsInvalidParameter, "style", "Unknown style: %q", req.style)
+	}
+	if req.set != l.d.Name {
+		return nil, owsError(owsInvalidParameter, "tilematrixset", "Unknown tile matrix set: %q", req.set)
+	}
+	if _, ok := wmtsFormats[req.format]; !ok {
+		return nil, owsError(owsInvalidParameter, "format", "Unsupported format %q, expecting image/png or image/jpeg", req.format)
+	}
+	i, err := strconv.Atoi(req.matrix)
+	if err != nil || i < 0 || i >= m.Levels {
+		return nil, owsError(owsInvalidParameter, "tilematrix", "Unknown tile matrix: %q", req.matrix)
+	}
+	level := m.Levels - 1 - i
+	tm := tileMatrixSet(l.d).TileMatrices[i]
+	row, err := strconv.Atoi(req.row)
+	if err != nil || row < 0 || row >= tm.MatrixHeight {
+		return nil, owsError("TileOutOfRange", "tilerow", "Invalid tile row: %q", req.row)
+	}
+	col, err := strconv.Atoi(req.col)
+	if err != nil || col < 0 || col >= tm.MatrixWidth {
+		return nil, owsError("TileOutOfRange", "tilecol", "Invalid tile column: %q", req.col)
+	}
+	t, err := timeIndex(m, req.time, false)
+	if err != nil {
+		return nil, owsError(owsInvalidParameter, "time", "%v", err)
+	}
+
+	bands, err := s.render(l, t, func(m *tiles.Manifest, read tiles.TileReader) (*image.Gray, error) {
+		return read(ctx, level, col, row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return compose(bands, m.NoData, false), nil
+}
+
+// writeTile encodes a tile in the format of req.
+func (s *Server) writeTile(w http.ResponseWriter, r *http.Request, req tileRequest) {
+	start := time.Now()
+	im, err := s.getTile(r.Context(), req)
+	if err != nil {
+		if _, ok := err.(*owsException); !ok {
+			log.Printf("Failed reading tile %+v: %v", req, err)
+			err = fmt.Errorf("Failed reading tile")
+		}
+		writeOWSException(w, err, "1.1.0")
+		return
+	}
+	var buf bytes.Buffer
+	if req.format == "image/jpeg" {
+		err = jpeg.Encode(&buf, im, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, im)
+	}
+	if err != nil {
+		writeOWSException(w, err, "1.1.0")
+		return
+	}
+	w.Header().Set("Content-Type", req.format)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	buf.WriteTo(w)
+	log.Printf("GetTile %+v: %v", req, time.Since(start))
+}
+
+// wmts serves the KVP encoding of WMTS 1.0.0 at /wmts.
+func (s *Server) wmts(w http.ResponseWriter, r *http.Request) {
+	q := ogcQuery(r)
+	if svc := q.Get("service"); svc != "" && strings.ToUpper(svc) != "WMTS" {
+		writeOWSException(w, owsError(owsInvalidParameter, "service", "Unsupported service %q, expecting WMTS", svc), "1.1.0")
+		return
+	}
+	switch req := q.Get("request"); req {
+	case "GetCapabilities":
+		s.wmtsCapabilities(w, r)
+	case "GetTile":
+		if v := q.Get("version"); v != "" && v != "1.0.0" {
+			writeOWSException(w, owsError(owsInvalidParameter, "version", "Unsupported version %q, expecting 1.0.0", v), "1.1.0")
+			return
+		}
+		for _, p := range []string{"layer", "tilematrixset", "tilematrix", "tilerow", "tilecol", "format"} {
+			if q.Get(p) == "" {
+				writeOWSException(w, owsError(owsMissingParameter, p, "Missing %s parameter", p), "1.1.0")
+				return
+			}
+		}
+		s.writeTile(w, r, tileRequest{layer: q.Get("layer"), style: q.Get("style"), set: q.Get("tilematrixset"),
+			matrix: q.Get("tilematrix"), row: q.Get("tilerow"), col: q.Get("tilecol"),
+			format: q.Get("format"), time: q.Get("time")})
+	case "":
+		writeOWSException(w, owsError(owsMissingParameter, "request", "Missing request parameter"), "1.1.0")
+	default:
+		writeOWSException(w, owsError(owsOperationNotSupported, "request", "Unsupported request %q, expecting GetCapabilities or GetTile", req), "1.1.0")
+	}
+}
+
+// wmtsREST serves the RESTful encoding of WMTS 1.0.0 under /wmts/1.0.0/:
+// WMTSCapabilities.xml and the tiles at
+// {layer}/{style}/[{time}/]{set}/{matrix}/{row}/{col}.{png,jpg}.
+func (s *Server) wmtsREST(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/wmts/1.0.0/")
+	if path == "WMTSCapabilities.xml" {
+		s.wmtsCapabilities(w, r)
+		return
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) != 6 && len(parts) != 7 {
+		http.NotFound(w, r)
+		return
+	}
+	req := tileRequest{layer: parts[0], style: parts[1]}
+	if len(parts) == 7 {
+		req.time = parts[2]
+		parts = append(parts[:2], parts[3:]...)
+	}
+	req.set, req.matrix, req.row = parts[2], parts[3], parts[4]
+	ext := strings.LastIndex(parts[5], ".")
+	if ext < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	req.col = parts[5][:ext]
+	for f, e := range wmtsFormats {
+		if e == parts[5][ext+1:] {
+			req.format = f
+		}
+	}
+	s.writeTile(w, r, req)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// wmtsCaps holds the contents of the WMTS capabilities read back by the
+// tests.
+type wmtsCaps struct {
+	XMLName xml.Name `xml:"http://www.opengis.net/wmts/1.0 Capabilities"`
+	Layers  []struct {
+		Identifier string
+		Set        string `xml:"TileMatrixSetLink>TileMatrixSet"`
+		Dimension  *struct {
+			Default string
+			Values  []string `xml:"Value"`
+		}
+		ResourceURLs []struct {
+			Format   string `xml:"format,attr"`
+			Template string `xml:"template,attr"`
+		} `xml:"ResourceURL"`
+	} `xml:"Contents>Layer"`
+	Sets []struct {
+		Identifier string
+		Matrices   []struct {
+			Identifier                string
+			ScaleDenominator          float64
+			TopLeftCorner             string
+			TileWidth, TileHeight     int
+			MatrixWidth, MatrixHeight int
+		} `xml:"TileMatrix"`
+	} `xml:"Contents>TileMatrixSet"`
+}
+
+func wmtsCapabilitiesOf(t *testing.T, s *Server, target string) wmtsCaps {
+	t.Helper()
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "text/xml")
+	var caps wmtsCaps
+	if err := xml.Unmarshal(w.Body.Bytes(), &caps); err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	return caps
+}
+
+func TestWMTSCapabilities(t *testing.T) {
+	s := newTestServer(t)
+	kvp := wmtsCapabilitiesOf(t, s, "/wmts?SERVICE=WMTS&REQUEST=GetCapabilities")
+	if rest := wmtsCapabilitiesOf(t, s, "/wmts/1.0.0/WMTSCapabilities.xml"); !reflect.DeepEqual(kvp, rest) {
+		t.Errorf("KVP and RESTful capabilities differ")
+	}
+
+	var layers, sets []string
+	for _, l := range kvp.Layers {
+		layers = append(layers, l.Identifier+"@"+l.Set)
+	}
+	for _, set := range kvp.Sets {
+		sets = append(sets, set.Identifier)
+	}
+	want := []string{"rgb@rgb", "rgb:red@rgb", "rgb:green@rgb", "rgb:blue@rgb", "series@series", "series:gray@series"}
+	if !reflect.DeepEqual(layers, want) || !reflect.DeepEqual(sets, []string{"rgb", "series"}) {
+		t.Fatalf("Layers %v and tile matrix sets %v, want %v and [rgb series]", layers, sets, want)
+	}
+
+	m := s.Datasets["rgb"].Manifest
+	matrices := kvp.Sets[0].Matrices
+	if len(matrices) != m.Levels {
+		t.Fatalf("%d tile matrices, want %d", len(matrices), m.Levels)
+	}
+	full := wmtsTileMatrix{Identifier: fmt.Sprint(m.Levels - 1), ScaleDenominator: 4 * metresPerDegree / 0.28e-3,
+		TopLeftCorner: "90 -180", TileWidth: 16, TileHeight: 16, MatrixWidth: 6, MatrixHeight: 3}
+	if tm := wmtsTileMatrix(matrices[m.Levels-1]); tm != full {
+		t.Errorf("Full resolution tile matrix %+v, want %+v", tm, full)
+	}
+	if tm := matrices[0]; tm.MatrixWidth != 1 || tm.MatrixHeight != 1 || tm.ScaleDenominator <= full.ScaleDenominator {
+		t.Errorf("Coarsest tile matrix %+v", tm)
+	}
+
+	series := kvp.Layers[4]
+	if d := series.Dimension; d == nil || d.Default != "2004-03-01" || !reflect.DeepEqual(d.Values, seriesTimes) {
+		t.Errorf("Series time dimension %+v", series.Dimension)
+	}
+	if len(series.ResourceURLs) != 2 || series.ResourceURLs[0].Template !=
+		"http://example.com/wmts/1.0.0/series/{Style}/{Time}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.png" {
+		t.Errorf("Series resource URLs %+v", series.ResourceURLs)
+	}
+}
+
+// Datasets of a catalog sharing a manifest have a tile matrix set each.
+func TestWMTSCapabilitiesSharedManifest(t *testing.T) {
+	s := newTestServer(t)
+	other := *s.Datasets["rgb"]
+	other.Name = "other"
+	s.Datasets["other"] = &other
+	caps := wmtsCapabilitiesOf(t, s, "/wmts?SERVICE=WMTS&REQUEST=GetCapabilities")
+	var sets []string
+	for _, set := range caps.Sets {
+		sets = append(sets, set.Identifier)
+	}
+	if !reflect.DeepEqual(sets, []string{"other", "rgb", "series"}) {
+		t.Errorf("Tile matrix sets %v, want [other rgb series]", sets)
+	}
+	for _, l := range caps.Layers {
+		if !strings.HasPrefix(l.Identifier, l.Set) {
+			t.Errorf("Layer %s links to tile matrix set %s", l.Identifier, l.Set)
+		}
+	}
+
+	level := s.Datasets["rgb"].Manifest.Levels - 1
+	target := fmt.Sprintf("/wmts/1.0.0/other/default/other/%d/0/0.png", level)
+	decodePNG(t, target, get(s, target))
+	target = fmt.Sprintf("/wmts/1.0.0/other/default/rgb/%d/0/0.png", level)
+	checkResponse(t, target, get(s, target), http.StatusBadRequest, "text/xml")
+}
+
+func TestWMTSGetTile(t *testing.T) {
+	s := newTestServer(t)
+	level := s.Datasets["rgb"].Manifest.Levels - 1
+	seriesLevel := s.Datasets["series"].Manifest.Levels - 1
+	for _, c := range []struct {
+		target string
+		x0, y0 int
+		// Band of the rgb dataset or, if negative, time index of the
+		// series dataset
+		band int
+	}{
+		{fmt.Sprintf("/wmts?SERVICE=WMTS&REQUEST=GetTile&VERSION=1.0.0&LAYER=rgb:red&STYLE=default&TILEMATRIXSET=rgb"+
+			"&TILEMATRIX=%d&TILEROW=1&TILECOL=2&FORMAT=image/png", level), 32, 16, 0},
+		{fmt.Sprintf("/wmts?service=WMTS&request=GetTile&layer=rgb:blue&tilematrixset=rgb"+
+			"&tilematrix=%d&tilerow=2&tilecol=5&format=image/png", level), 80, 32, 2},
+		{fmt.Sprintf("/wmts/1.0.0/rgb:green/default/rgb/%d/0/3.png", level), 48, 0, 1},
+		{fmt.Sprintf("/wmts/1.0.0/series:gray/default/2004-02-01/series/%d/0/0.png", seriesLevel), 0, 0, -2},
+		{fmt.Sprintf("/wmts/1.0.0/series:gray/default/series/%d/0/0.png", seriesLevel), 0, 0, -3},
+	} {
+		im := decodePNG(t, c.target, get(s, c.target))
+		if b := im.Bounds(); b.Dx() != 16 || b.Dy() != 16 {
+			t.Fatalf("GET %s: %dx%d tile, want 16x16", c.target, b.Dx(), b.Dy())
+		}
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				if c.x0+x >= 90 || c.y0+y >= 45 {
+					// Padding of the edge tiles
+					continue
+				}
+				want := rgbValue(c.x0+x, c.y0+y, c.band)
+				if c.band < 0 {
+					if x >= 20 || y >= 10 {
+						continue
+					}
+					want = seriesValue(x, y, -c.band-1)
+				}
+				if got := grayAt(im, x, y); got != want {
+					t.Fatalf("GET %s: pixel %d, %d is %d, want %d", c.target, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	target := fmt.Sprintf("/wmts/1.0.0/rgb/default/rgb/%d/1/1.jpg", level)
+	w := get(s, target)
+	checkResponse(t, target, w, http.StatusOK, "image/jpeg")
+	im, err := jpeg.Decode(w.Body)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	if im.Bounds() != image.Rect(0, 0, 16, 16) {
+		t.Errorf("GET %s: %v tile", target, im.Bounds())
+	}
+}
+
+func TestWMTSErrors(t *testing.T) {
+	s := newTestServer(t)
+	level := s.Datasets["rgb"].Manifest.Levels - 1
+	getTile := "/wmts?SERVICE=WMTS&REQUEST=GetTile&VERSION=1.0.0"
+	valid := fmt.Sprintf("&LAYER=rgb&TILEMATRIXSET=rgb&TILEMATRIX=%d&TILEROW=2&TILECOL=5&FORMAT=image/png", level)
+	for _, c := range []struct {
+		target  string
+		status  int
+		code    string
+		locator string
+	}{
+		{"/wmts?SERVICE=WMS&REQUEST=GetCapabilities", http.StatusBadRequest, owsInvalidParameter, "service"},
+		{"/wmts?SERVICE=WMTS", http.StatusBadRequest, owsMissingParameter, "request"},
+		{"/wmts?SERVICE=WMTS&REQUEST=GetFeatureInfo", http.StatusNotImplemented, owsOperationNotSupported, "request"},
+		{strings.Replace(getTile, "1.0.0", "2.0.0", 1) + valid, http.StatusBadRequest, owsInvalidParameter, "version"},
+		{getTile + strings.Replace(valid, "&TILEROW=2", "", 1), http.StatusBadRequest, owsMissingParameter, "tilerow"},
+		{getTile + strings.Replace(valid, "LAYER=rgb", "LAYER=nowhere", 1), http.StatusBadRequest, owsInvalidParameter, "layer"},
+		{getTile + valid + "&STYLE=fancy", http.StatusBadRequest, owsInvalidParameter, "style"},
+		{getTile + strings.Replace(valid, "TILEMATRIXSET=rgb", "TILEMATRIXSET=series", 1), http.StatusBadRequest, owsInvalidParameter, "tilematrixset"},
+		{getTile + strings.Replace(valid, "image/png", "image/gif", 1), http.StatusBadRequest, owsInvalidParameter, "format"},
+		{getTile + strings.Replace(valid, fmt.Sprintf("TILEMATRIX=%d", level), fmt.Sprintf("TILEMATRIX=%d", level+1), 1),
+			http.StatusBadRequest, owsInvalidParameter, "tilematrix"},
+		{getTile + strings.Replace(valid, "TILEROW=2", "TILEROW=3", 1), http.StatusBadRequest, "TileOutOfRange", "tilerow"},
+		{getTile + strings.Replace(valid, "TILECOL=5", "TILECOL=-1", 1), http.StatusBadRequest, "TileOutOfRange", "tilecol"},
+		{getTile + valid + "&TIME=2004-01-01", http.StatusBadRequest, owsInvalidParameter, "time"},
+		// Tiles are only served at the dates of the series
+		{"/wmts/1.0.0/series/default/2004-02-10/series/0/0/0.png", http.StatusBadRequest, owsInvalidParameter, "time"},
+		{"/wmts/1.0.0/rgb/default/rgb/0/0/0.gif", http.StatusBadRequest, owsInvalidParameter, "format"},
+	} {
+		w := get(s, c.target)
+		checkResponse(t, c.target, w, c.status, "text/xml")
+		var report struct {
+			XMLName   xml.Name `xml:"http://www.opengis.net/ows/1.1 ExceptionReport"`
+			Version   string   `xml:"version,attr"`
+			Exception owsException
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("GET %s: %v", c.target, err)
+		}
+		if e := report.Exception; report.Version != "1.1.0" || e.Code != c.code || e.Locator != c.locator || e.Text == "" {
+			t.Errorf("GET %s: exception %+v, want %s at %s", c.target, e, c.code, c.locator)
+		}
+	}
+
+	for _, target := range []string{"/wmts/1.0.0/rgb/default/rgb/0/0", "/wmts/1.0.0/rgb/default/rgb/0/0/0"} {
+		checkResponse(t, target, get(s, target), http.StatusNotFound, "")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prl900/earth_data_server/tiles"
+)
+
+// xyzTileSize is the size of the Web Mercator tiles.
+const xyzTileSize = 256
+
+// parseXYZ parses the z/x/y.png path of a Web Mercator tile.
+func parseXYZ(path string) (z, x, y int, err error) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], ".png") {
+		return 0, 0, 0, fmt.Errorf("Invalid tile path %q, expecting {z}/{x}/{y}.png", path)
+	}
+	parts[2] = strings.TrimSuffix(parts[2], ".png")
+	var v [3]int
+	for i, p := range parts {
+		if v[i], err = strconv.Atoi(p); err != nil {
+			return 0, 0, 0, fmt.Errorf("Invalid tile path %q, expecting {z}/{x}/{y}.png", path)
+		}
+	}
+	return v[0], v[1], v[2], nil
+}
+
+// xyz serves the Web Mercator tiles of a layer at /xyz/{layer}/{z}/{x}/{y}.png
+// and of the default dataset at /{z}/{x}/{y}.png.
+func (s *Server) xyz(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	var l layer
+	if strings.HasPrefix(path, "xyz/") {
+		parts := strings.SplitN(strings.TrimPrefix(path, "xyz/"), "/", 2)
+		var ok bool
+		if l, ok = s.layer(parts[0]); !ok {
+			http.Error(w, fmt.Sprintf("Unknown layer: %q", parts[0]), http.StatusNotFound)
+			return
+		}
+		if len(parts) == 2 {
+			path = parts[1]
+		}
+	} else {
+		d, ok := s.Datasets[s.Default]
+		if !ok || strings.Count(path, "/") != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		l = datasetLayers(d)[0]
+	}
+	z, x, y, err := parseXYZ(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if _, err := tiles.MercatorBBox(z, x, y); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	m := l.d.Manifest
+	t, err := parseTime(r, m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	transparent := false
+	if tr := r.FormValue("transparent"); tr != "" {
+		if transparent, err = strconv.ParseBool(tr); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid transparent parameter: %q", tr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	bands, err := s.render(l, t, func(m *tiles.Manifest, read tiles.TileReader) (*image.Gray, error) {
+		return m.MosaicMercator(r.Context(), z, x, y, xyzTileSize, read)
+	})
+	if err != nil {
+		log.Printf("Failed generating tile %s %d/%d/%d: %v", l.Name, z, x, y, err)
+		http.Error(w, "Failed generating tile", http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, compose(bands, m.NoData, transparent)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if t >= 0 {
+		w.Header().Set("X-Time", m.Times[t])
+	}
+	buf.WriteTo(w)
+	log.Printf("Tile %s %d/%d/%d: %v", l.Name, z, x, y, time.Since(start))
+}
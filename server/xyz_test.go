@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"net/http"
+	"testing"
+
+	"github.com/prl900/earth_data_server/tiles"
+)
+
+func TestXYZ(t *testing.T) {
+	s := newTestServer(t)
+	n := 1 << uint(tiles.MaxMercatorZoom)
+	for _, c := range []struct {
+		target string
+		// Color of every pixel of the tile, if not zero
+		color color.NRGBA
+	}{
+		{"/0/0/0.png", color.NRGBA{}},
+		{"/xyz/rgb/2/3/1.png", color.NRGBA{}},
+		// The deepest tiles are within a pixel
+		{fmt.Sprintf("/xyz/rgb:green/%d/%d/%d.png", tiles.MaxMercatorZoom, n/2, n/2),
+			color.NRGBA{rgbValue(45, 22, 1), rgbValue(45, 22, 1), rgbValue(45, 22, 1), 255}},
+		{fmt.Sprintf("/%d/0/0.png", tiles.MaxMercatorZoom),
+			color.NRGBA{rgbValue(0, 1, 0), rgbValue(0, 1, 1), rgbValue(0, 1, 2), 255}},
+	} {
+		w := get(s, c.target)
+		im := decodePNG(t, c.target, w)
+		if b := im.Bounds(); b.Dx() != xyzTileSize || b.Dy() != xyzTileSize {
+			t.Fatalf("GET %s: %dx%d tile, want %dx%d", c.target, b.Dx(), b.Dy(), xyzTileSize, xyzTileSize)
+		}
+		if c.color == (color.NRGBA{}) {
+			continue
+		}
+		for y := 0; y < xyzTileSize; y++ {
+			for x := 0; x < xyzTileSize; x++ {
+				if got := color.NRGBAModel.Convert(im.At(x, y)); got != c.color {
+					t.Fatalf("GET %s: pixel %d, %d is %v, want %v", c.target, x, y, got, c.color)
+				}
+			}
+		}
+	}
+}
+
+func TestXYZTime(t *testing.T) {
+	s := newTestServer(t)
+	// The north west tile covers the whole series dataset
+	target := "/xyz/series/2/0/0.png?time=2004-02-01&transparent=true"
+	w := get(s, target)
+	im := decodePNG(t, target, w)
+	if tm := w.Header().Get("X-Time"); tm != "2004-02-01" {
+		t.Errorf("GET %s: time %q", target, tm)
+	}
+	if _, _, _, a := im.At(0, 0).RGBA(); a == 0 {
+		t.Errorf("GET %s: top left pixel is transparent", target)
+	}
+	if _, _, _, a := im.At(xyzTileSize-1, xyzTileSize-1).RGBA(); a != 0 {
+		t.Errorf("GET %s: bottom right pixel outside the dataset is opaque", target)
+	}
+}
+
+func TestXYZErrors(t *testing.T) {
+	s := newTestServer(t)
+	for _, c := range []struct {
+		target string
+		status int
+	}{
+		{"/xyz/nowhere/0/0/0.png", http.StatusNotFound},
+		{"/xyz/rgb/0/0/0.jpg", http.StatusNotFound},
+		{"/xyz/rgb/0/0.png", http.StatusNotFound},
+		{"/xyz/rgb/a/0/0.png", http.StatusNotFound},
+		{fmt.Sprintf("/xyz/rgb/%d/0/0.png", tiles.MaxMercatorZoom+1), http.StatusNotFound},
+		{"/xyz/rgb/1/2/0.png", http.StatusNotFound},
+		{"/xyz/rgb/1/0/-1.png", http.StatusNotFound},
+		{"/0/0.png", http.StatusNotFound},
+		{"/xyz/rgb/0/0/0.png?transparent=maybe", http.StatusBadRequest},
+		{"/xyz/rgb/0/0/0.png?time=2004-01-01", http.StatusBadRequest},
+		{"/xyz/series/0/0/0.png?time=2005-01-01", http.StatusBadRequest},
+	} {
+		checkResponse(t, c.target, get(s, c.target), c.status, "")
+	}
+
+	s.Default = ""
+	checkResponse(t, "/0/0/0.png", get(s, "/0/0/0.png"), http.StatusNotFound, "")
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// fsStore keeps each tile as a file under a root directory.
+type fsStore struct {
+	root string
+}
+
+// NewFS returns a store of the files under the directory root.
+func NewFS(root string) TileStore {
+	return &fsStore{root: root}
+}
+
+func (s *fsStore) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+func notExist(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNotExist
+	}
+	return err
+}
+
+func (s *fsStore) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(name))
+	return data, notExist(err)
+}
+
+func (s *fsStore) Put(ctx context.Context, name string, data []byte) error {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, data, 0644)
+}
+
+func (s *fsStore) Stat(ctx context.Context, name string) (Info, error) {
+	fi, err := os.Stat(s.path(name))
+	if err != nil {
+		return Info{}, notExist(err)
+	}
+	return Info{Name: name, Size: fi.Size()}, nil
+}
+
+func (s *fsStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(s.root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func (s *fsStore) Delete(ctx context.Context, name string) error {
+	return notExist(os.Remove(s.path(name)))
+}
+
+func (s *fsStore) Close() error { return nil }
+
+func init() {
+	Register("file", func(ctx context.Context, u *url.URL) (TileStore, error) {
+		// file://tiles is the relative directory tiles
+		root := u.Host + u.Path
+		if root == "" {
+			root = "."
+		}
+		return NewFS(root), nil
+	})
+}
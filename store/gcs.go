@@ -0,0 +1,190 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsStore keeps each tile as an object of a Google Cloud Storage bucket.
+type gcsStore struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+	prefix string
+	// owned clients are closed with the store
+	owned bool
+}
+
+// NewGCS returns a store of the objects under prefix in bucket. The client
+// is not closed by the store.
+func NewGCS(client *storage.Client, bucket, prefix string) TileStore {
+	return &gcsStore{client: client, bucket: client.Bucket(bucket), prefix: prefix}
+}
+
+func (s *gcsStore) object(name string) *storage.ObjectHandle {
+	return s.bucket.Object(path.Join(s.prefix, name))
+}
+
+func gcsError(err error) error {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return ErrNotExist
+	}
+	return err
+}
+
+func (s *gcsStore) Get(ctx context.Context, name string) ([]byte, error) {
+	rc, err := s.object(name).NewReader(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading object: %v", err)
+	}
+	return data, nil
+}
+
+func (s *gcsStore) Put(ctx context.Context, name string, data []byte) error {
+	w := s.object(name).NewWriter(ctx)
+	w.ContentType = "application/octet-stream"
+
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("Failed to write object to bucket: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("Failed to close writer to bucket: %v", err)
+	}
+	return nil
+}
+
+func (s *gcsStore) Stat(ctx context.Context, name string) (Info, error) {
+	attrs, err := s.object(name).Attrs(ctx)
+	if err != nil {
+		return Info{}, gcsError(err)
+	}
+	return Info{Name: name, Size: attrs.Size}, nil
+}
+
+func (s *gcsStore) List(ctx context.Context, prefix string) ([]string, error) {
+	root := s.prefix
+	if root != "" {
+		root += "/"
+	}
+	var names []string
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: root + prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed listing objects: %v", err)
+		}
+		names = append(names, strings.TrimPrefix(attrs.Name, root))
+	}
+	return names, nil
+}
+
+func (s *gcsStore) Delete(ctx context.Context, name string) error {
+	return gcsError(s.object(name).Delete(ctx))
+}
+
+func (s *gcsStore) Close() error {
+	if s.owned {
+		return s.client.Close()
+	}
+	return nil
+}
+
+// gcsRange reads ranges of a GCS object.
+type gcsRange struct {
+	obj *storage.ObjectHandle
+	// owned clients are closed with the reader
+	client *storage.Client
+}
+
+// NewGCSRange returns a RangeReader of an object.
+func NewGCSRange(obj *storage.ObjectHandle) RangeReader {
+	return &gcsRange{obj: obj}
+}
+
+func (r *gcsRange) ReadRange(ctx context.Context, off, n int64) ([]byte, error) {
+	rc, err := r.obj.NewRangeReader(ctx, off, n)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading object: %v", err)
+	}
+	if int64(len(data)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+func (r *gcsRange) Size(ctx context.Context) (int64, error) {
+	attrs, err := r.obj.Attrs(ctx)
+	if err != nil {
+		return 0, gcsError(err)
+	}
+	return attrs.Size, nil
+}
+
+func (r *gcsRange) Close() error {
+	if r.client != nil {
+		return r.client.Close()
+	}
+	return nil
+}
+
+// gcsOptions returns the client options selected by the query of a gs://
+// URL. The endpoint parameter points the client to an emulator, without
+// authentication. The client also honours STORAGE_EMULATOR_HOST.
+func gcsOptions(u *url.URL) []option.ClientOption {
+	endpoint := u.Query().Get("endpoint")
+	if endpoint == "" {
+		return nil
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	return []option.ClientOption{
+		option.WithEndpoint(strings.TrimSuffix(endpoint, "/") + "/storage/v1/"),
+		option.WithoutAuthentication(),
+	}
+}
+
+func init() {
+	Register("gs", func(ctx context.Context, u *url.URL) (TileStore, error) {
+		client, err := storage.NewClient(ctx, gcsOptions(u)...)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create client: %v", err)
+		}
+		s := NewGCS(client, u.Host, strings.Trim(u.Path, "/")).(*gcsStore)
+		s.owned = true
+		return s, nil
+	})
+	Register("pack+gs", func(ctx context.Context, u *url.URL) (TileStore, error) {
+		client, err := storage.NewClient(ctx, gcsOptions(u)...)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create client: %v", err)
+		}
+		r := &gcsRange{obj: client.Bucket(u.Host).Object(strings.TrimPrefix(u.Path, "/")), client: client}
+		return OpenPackReader(ctx, r, "gs://"+u.Host+u.Path)
+	})
+}
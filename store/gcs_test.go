@@ -0,0 +1,69 @@
+package store_test
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prl900/earth_data_server/store/gcsfake"
+	"github.com/prl900/earth_data_server/tiles"
+	"golang.org/x/net/context"
+)
+
+func newGCS(t *testing.T) *gcsfake.Server {
+	srv := gcsfake.NewServer()
+	t.Cleanup(srv.Close)
+	srv.CreateBucket(bucket)
+	return srv
+}
+
+func TestGCSEndpoint(t *testing.T) {
+	srv := newGCS(t)
+	checkStore(t, "gs://"+bucket+"/endpoint?endpoint="+url.QueryEscape(srv.URL))
+	if objs := srv.Objects(bucket); len(objs) != 8*4 {
+		t.Errorf("%d objects left in the bucket, want %d", len(objs), 8*4)
+	}
+}
+
+func TestGCSEmulatorHost(t *testing.T) {
+	srv := newGCS(t)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	checkStore(t, "gs://"+bucket+"/emulator")
+}
+
+func TestGCSPack(t *testing.T) {
+	srv := newGCS(t)
+	checkPack(t, "gs://"+bucket+"/packed?endpoint="+url.QueryEscape(srv.URL))
+}
+
+// Buckets without manifest hold the objects uploaded by part 3, named
+// without extension.
+func TestGCSPart3Objects(t *testing.T) {
+	srv := newGCS(t)
+	ctx := context.Background()
+	d, err := tiles.OpenDataset(ctx, "gs://"+bucket+"?endpoint="+url.QueryEscape(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	m := d.Manifest
+	pix := bytes.Repeat([]byte{42}, m.TileSize*m.TileSize)
+	name := "world.topo.bathy.200412.3x400x400.03.10.green"
+	if err := d.Store.Put(ctx, name, snappy.Encode(nil, pix)); err != nil {
+		t.Fatal(err)
+	}
+	read, err := m.StoreReader(d.Store, m.Codecs[0], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile, err := read(ctx, 0, 3, 10)
+	if err != nil {
+		t.Fatalf("Failed reading part 3 object: %v", err)
+	}
+	if !bytes.Equal(tile.Pix, pix) {
+		t.Errorf("Unexpected pixels of part 3 object %s", name)
+	}
+}
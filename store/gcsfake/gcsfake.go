@@ -0,0 +1,329 @@
+// Package gcsfake runs an in-process server emulating the subset of the
+// Google Cloud Storage JSON and XML APIs used by the gs:// tile store, so
+// the cloud path can be exercised offline. Objects are kept in memory.
+package gcsfake
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake GCS server listening on a local port.
+type Server struct {
+	// URL is the endpoint of the server, suitable for the
+	// STORAGE_EMULATOR_HOST environment variable.
+	URL string
+
+	srv     *httptest.Server
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+	uploads map[string]*upload
+	nextID  int
+}
+
+// upload is a resumable upload in progress.
+type upload struct {
+	bucket, name string
+	data         []byte
+}
+
+type object struct {
+	Kind        string `json:"kind"`
+	Bucket      string `json:"bucket"`
+	Name        string `json:"name"`
+	Size        string `json:"size"`
+	ContentType string `json:"contentType,omitempty"`
+	Generation  string `json:"generation"`
+}
+
+// NewServer starts a server with no buckets.
+func NewServer() *Server {
+	s := &Server{buckets: map[string]map[string][]byte{}, uploads: map[string]*upload{}}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// CreateBucket adds an empty bucket.
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[name]; !ok {
+		s.buckets[name] = map[string][]byte{}
+	}
+}
+
+// Objects returns the sorted names of the objects of a bucket.
+func (s *Server) Objects(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.buckets[bucket] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func jsonError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": msg},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func newObject(bucket, name string, data []byte) object {
+	return object{Kind: "storage#object", Bucket: bucket, Name: name,
+		Size: strconv.Itoa(len(data)), ContentType: "application/octet-stream", Generation: "1"}
+}
+
+// splitPath returns the unescaped segments of the path after prefix.
+func splitPath(r *http.Request, prefix string) ([]string, bool) {
+	p := r.URL.EscapedPath()
+	if !strings.HasPrefix(p, prefix) {
+		return nil, false
+	}
+	parts := strings.Split(strings.TrimPrefix(p, prefix), "/")
+	for i, part := range parts {
+		u, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = u
+	}
+	return parts, true
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if parts, ok := splitPath(r, "/upload/storage/v1/b/"); ok && len(parts) == 2 && parts[1] == "o" {
+		s.upload(w, r, parts[0])
+		return
+	}
+	if parts, ok := splitPath(r, "/storage/v1/b/"); ok {
+		switch {
+		case len(parts) == 2 && parts[1] == "o" && r.Method == "GET":
+			s.list(w, r, parts[0])
+			return
+		case len(parts) >= 3 && parts[1] == "o":
+			// Object names are escaped as a single segment but accept them
+			// unescaped too
+			s.object(w, r, parts[0], strings.Join(parts[2:], "/"))
+			return
+		}
+		jsonError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	// XML API download: /bucket/object
+	if parts, ok := splitPath(r, "/"); ok && len(parts) >= 2 && (r.Method == "GET" || r.Method == "HEAD") {
+		s.download(w, r, parts[0], strings.Join(parts[1:], "/"))
+		return
+	}
+	jsonError(w, http.StatusNotFound, "Not found")
+}
+
+func (s *Server) get(bucket, name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.buckets[bucket][name]
+	return data, ok
+}
+
+func (s *Server) put(bucket, name string, data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if ok {
+		b[name] = data
+	}
+	return ok
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	data, ok := s.get(bucket, name)
+	if !ok {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("X-Goog-Generation", "1")
+	w.Header().Set("X-Goog-Metageneration", "1")
+	// ServeContent handles the Range requests
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (s *Server) object(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	switch r.Method {
+	case "GET":
+		data, ok := s.get(bucket, name)
+		if !ok {
+			jsonError(w, http.StatusNotFound, "No such object: "+bucket+"/"+name)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			s.download(w, r, bucket, name)
+			return
+		}
+		writeJSON(w, newObject(bucket, name, data))
+	case "DELETE":
+		s.mu.Lock()
+		_, ok := s.buckets[bucket][name]
+		delete(s.buckets[bucket], name)
+		s.mu.Unlock()
+		if !ok {
+			jsonError(w, http.StatusNotFound, "No such object: "+bucket+"/"+name)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	s.mu.Lock()
+	b, ok := s.buckets[bucket]
+	prefix := r.URL.Query().Get("prefix")
+	var items []object
+	for name, data := range b {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, newObject(bucket, name, data))
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		jsonError(w, http.StatusNotFound, "No such bucket: "+bucket)
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	writeJSON(w, map[string]interface{}{"kind": "storage#objects", "items": items})
+}
+
+// upload handles the media, multipart and resumable upload protocols.
+func (s *Server) upload(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	var data []byte
+	var err error
+
+	switch q.Get("uploadType") {
+	case "media":
+		data, err = ioutil.ReadAll(r.Body)
+	case "multipart":
+		name, data, err = readMultipart(r, name)
+	case "resumable":
+		s.resumable(w, r, bucket, name)
+		return
+	default:
+		jsonError(w, http.StatusBadRequest, "Unsupported uploadType: "+q.Get("uploadType"))
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.put(bucket, name, data) {
+		jsonError(w, http.StatusNotFound, "No such bucket: "+bucket)
+		return
+	}
+	writeJSON(w, newObject(bucket, name, data))
+}
+
+func readMultipart(r *http.Request, name string) (string, []byte, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return "", nil, err
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	meta, err := mr.NextPart()
+	if err != nil {
+		return "", nil, err
+	}
+	var obj object
+	if err := json.NewDecoder(meta).Decode(&obj); err != nil {
+		return "", nil, err
+	}
+	if obj.Name != "" {
+		name = obj.Name
+	}
+	media, err := mr.NextPart()
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := ioutil.ReadAll(media)
+	return name, data, err
+}
+
+func (s *Server) resumable(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	if id := r.URL.Query().Get("upload_id"); id != "" {
+		s.mu.Lock()
+		up, ok := s.uploads[id]
+		s.mu.Unlock()
+		if !ok {
+			jsonError(w, http.StatusNotFound, "No such upload: "+id)
+			return
+		}
+		chunk, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		up.data = append(up.data, chunk...)
+		// Content-Range is "bytes first-last/total", total being "*"
+		// until the last chunk
+		cr := r.Header.Get("Content-Range")
+		if total := cr[strings.LastIndex(cr, "/")+1:]; total == "*" || total != strconv.Itoa(len(up.data)) {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(up.data)-1))
+			w.WriteHeader(http.StatusPermanentRedirect)
+			return
+		}
+		s.mu.Lock()
+		delete(s.uploads, id)
+		s.mu.Unlock()
+		if !s.put(up.bucket, up.name, up.data) {
+			jsonError(w, http.StatusNotFound, "No such bucket: "+up.bucket)
+			return
+		}
+		writeJSON(w, newObject(up.bucket, up.name, up.data))
+		return
+	}
+
+	var obj object
+	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil && err != io.EOF {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if obj.Name != "" {
+		name = obj.Name
+	}
+	s.mu.Lock()
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	s.uploads[id] = &upload{bucket: bucket, name: name}
+	s.mu.Unlock()
+	w.Header().Set("Location", fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable&upload_id=%s",
+		s.URL, url.PathEscape(bucket), id))
+	w.WriteHeader(http.StatusOK)
+}
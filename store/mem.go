@@ -0,0 +1,94 @@
+package store
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// memStore keeps the tiles in memory. It is mostly useful for tests.
+type memStore struct {
+	mu    sync.RWMutex
+	tiles map[string][]byte
+}
+
+// NewMem returns an empty in-memory store.
+func NewMem() TileStore {
+	return &memStore{tiles: map[string][]byte{}}
+}
+
+var (
+	memMu     sync.Mutex
+	memStores = map[string]TileStore{}
+)
+
+func (s *memStore) Get(ctx context.Context, name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.tiles[name]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *memStore) Put(ctx context.Context, name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tiles[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memStore) Stat(ctx context.Context, name string) (Info, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.tiles[name]
+	if !ok {
+		return Info{}, ErrNotExist
+	}
+	return Info{Name: name, Size: int64(len(data))}, nil
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var names []string
+	for name := range s.tiles {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *memStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tiles[name]; !ok {
+		return ErrNotExist
+	}
+	delete(s.tiles, name)
+	return nil
+}
+
+func (s *memStore) Close() error { return nil }
+
+func init() {
+	// Stores opened with the same mem://name URL share their tiles for the
+	// life of the process.
+	Register("mem", func(ctx context.Context, u *url.URL) (TileStore, error) {
+		memMu.Lock()
+		defer memMu.Unlock()
+		name := u.Host + u.Path
+		s, ok := memStores[name]
+		if !ok {
+			s = NewMem()
+			memStores[name] = s
+		}
+		return s, nil
+	})
+}
@@ -0,0 +1,301 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// A pack keeps all the tiles of a store in a single file, so they can be
+// copied, checksummed and served as one object. All integers are little
+// endian:
+//
+//	header: magic "EDSTPACK", version uint32, count uint32, index size uint64
+//	index:  count entries of name length uint16, name, offset uint64, length uint64
+//	tiles:  the encoded tiles, in the order they were put
+//
+// Offsets are relative to the start of the file. The header and the index
+// are read when the pack is opened, then each tile takes a single ranged
+// read. Packs can also be read from object stores and HTTP servers with
+// the pack+gs://, pack+s3://, pack+http:// and pack+https:// URLs.
+const (
+	packMagic   = "EDSTPACK"
+	packVersion = 1
+	packHeader  = 24
+	// packEntryMin is the size of an index entry with an empty name
+	packEntryMin = 2 + 16
+)
+
+type packEntry struct {
+	off, n int64
+}
+
+// packStore reads the tiles of a pack or, when created, writes them.
+type packStore struct {
+	path  string
+	mu    sync.RWMutex
+	index map[string]packEntry
+	// r reads an existing pack
+	r RangeReader
+	// Created packs keep the tiles in a temporary file, in order, until
+	// the pack is written by Close
+	blobs *os.File
+	order []string
+	size  int64
+}
+
+// readPackIndex reads the header and the index of a pack.
+func readPackIndex(ctx context.Context, r RangeReader) (map[string]packEntry, error) {
+	total, err := r.Size(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading pack size: %v", err)
+	}
+	if total < packHeader {
+		return nil, fmt.Errorf("Not a tile pack")
+	}
+	hdr, err := r.ReadRange(ctx, 0, packHeader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading pack header: %v", err)
+	}
+	if string(hdr[:8]) != packMagic {
+		return nil, fmt.Errorf("Not a tile pack")
+	}
+	if v := binary.LittleEndian.Uint32(hdr[8:]); v != packVersion {
+		return nil, fmt.Errorf("Unsupported pack version: %d", v)
+	}
+	count := int64(binary.LittleEndian.Uint32(hdr[12:]))
+	size := int64(binary.LittleEndian.Uint64(hdr[16:]))
+	// The header of corrupt packs must not drive the allocations
+	if size < 0 || size > total-packHeader || count*packEntryMin > size {
+		return nil, fmt.Errorf("Invalid pack index of %d tiles in %d bytes, the pack has %d bytes", count, size, total)
+	}
+	data, err := r.ReadRange(ctx, packHeader, size)
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading pack index: %v", err)
+	}
+
+	index := make(map[string]packEntry, count)
+	for i := int64(0); i < count; i++ {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("Truncated pack index")
+		}
+		l := int(binary.LittleEndian.Uint16(data))
+		if len(data) < 2+l+16 {
+			return nil, fmt.Errorf("Truncated pack index")
+		}
+		e := packEntry{off: int64(binary.LittleEndian.Uint64(data[2+l:])),
+			n: int64(binary.LittleEndian.Uint64(data[2+l+8:]))}
+		if e.off < packHeader+size || e.n < 0 || e.n > total-e.off {
+			return nil, fmt.Errorf("Invalid pack entry %q: %d+%d", data[2:2+l], e.off, e.n)
+		}
+		index[string(data[2:2+l])] = e
+		data = data[2+l+16:]
+	}
+	return index, nil
+}
+
+// OpenPack returns a read-only store of the tiles of the pack at path.
+func OpenPack(ctx context.Context, path string) (TileStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenPackReader(ctx, fileRange{f}, path)
+}
+
+// OpenPackReader returns a read-only store of the tiles of the pack read
+// by r, named name in the errors. The store closes r.
+func OpenPackReader(ctx context.Context, r RangeReader, name string) (TileStore, error) {
+	index, err := readPackIndex(ctx, r)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("Failed opening pack %s: %v", name, err)
+	}
+	return &packStore{path: name, index: index, r: r}, nil
+}
+
+// CreatePack returns a store whose tiles are written to a new pack at path
+// when it is closed.
+func CreatePack(path string) (TileStore, error) {
+	blobs, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tiles")
+	if err != nil {
+		return nil, err
+	}
+	return &packStore{path: path, index: map[string]packEntry{}, blobs: blobs}, nil
+}
+
+// reader returns the reader of the tiles.
+func (s *packStore) reader() RangeReader {
+	if s.r != nil {
+		return s.r
+	}
+	return fileRange{s.blobs}
+}
+
+func (s *packStore) Get(ctx context.Context, name string) ([]byte, error) {
+	s.mu.RLock()
+	e, ok := s.index[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return s.reader().ReadRange(ctx, e.off, e.n)
+}
+
+// GetMulti reads the tiles with ReadRanges, so tiles stored next to each
+// other are read together.
+func (s *packStore) GetMulti(ctx context.Context, names []string) ([][]byte, error) {
+	ranges := make([]ByteRange, len(names))
+	s.mu.RLock()
+	for i, name := range names {
+		e, ok := s.index[name]
+		if !ok {
+			s.mu.RUnlock()
+			return nil, ErrNotExist
+		}
+		ranges[i] = ByteRange{Off: e.off, N: e.n}
+	}
+	s.mu.RUnlock()
+	return ReadRanges(ctx, s.reader(), ranges)
+}
+
+func (s *packStore) Put(ctx context.Context, name string, data []byte) error {
+	if s.blobs == nil {
+		return fmt.Errorf("Pack %s is read-only", s.path)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.blobs.WriteAt(data, s.size); err != nil {
+		return err
+	}
+	if _, ok := s.index[name]; !ok {
+		s.order = append(s.order, name)
+	}
+	s.index[name] = packEntry{off: s.size, n: int64(len(data))}
+	s.size += int64(len(data))
+	return nil
+}
+
+func (s *packStore) Stat(ctx context.Context, name string) (Info, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.index[name]
+	if !ok {
+		return Info{}, ErrNotExist
+	}
+	return Info{Name: name, Size: e.n}, nil
+}
+
+func (s *packStore) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var names []string
+	for name := range s.index {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *packStore) Delete(ctx context.Context, name string) error {
+	if s.blobs == nil {
+		return fmt.Errorf("Pack %s is read-only", s.path)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.index[name]; !ok {
+		return ErrNotExist
+	}
+	delete(s.index, name)
+	return nil
+}
+
+// Close writes created packs. The pack replaces path only once complete.
+func (s *packStore) Close() error {
+	if s.blobs == nil {
+		return s.r.Close()
+	}
+	defer os.Remove(s.blobs.Name())
+	defer s.blobs.Close()
+	if err := s.write(); err != nil {
+		return fmt.Errorf("Failed writing pack %s: %v", s.path, err)
+	}
+	return nil
+}
+
+func (s *packStore) write() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	size := int64(0)
+	for _, name := range s.order {
+		if _, ok := s.index[name]; ok {
+			names = append(names, name)
+			size += int64(2 + len(name) + 16)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(packMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(packVersion))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(names)))
+	binary.Write(&buf, binary.LittleEndian, uint64(size))
+	off := packHeader + size
+	for _, name := range names {
+		e := s.index[name]
+		binary.Write(&buf, binary.LittleEndian, uint16(len(name)))
+		buf.WriteString(name)
+		binary.Write(&buf, binary.LittleEndian, uint64(off))
+		binary.Write(&buf, binary.LittleEndian, uint64(e.n))
+		off += e.n
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if _, err := buf.WriteTo(f); err != nil {
+		return err
+	}
+	// Deleted and replaced tiles are left behind
+	for _, name := range names {
+		e := s.index[name]
+		if _, err := io.Copy(f, io.NewSectionReader(s.blobs, e.off, e.n)); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+func init() {
+	// Packs are only created explicitly with CreatePack, so a mistyped
+	// path fails instead of reading as an empty store
+	Register("pack", func(ctx context.Context, u *url.URL) (TileStore, error) {
+		// pack://tiles.pack is the relative file tiles.pack
+		path := u.Host + u.Path
+		if path == "" {
+			return nil, fmt.Errorf("Missing pack file in %q", u.String())
+		}
+		return OpenPack(ctx, path)
+	})
+}
@@ -0,0 +1,100 @@
+package store_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prl900/earth_data_server/store"
+	"golang.org/x/net/context"
+)
+
+func TestPack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "world.pack")
+	if err := ioutil.WriteFile(path, makePack(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	st, err := store.Open(ctx, "pack://"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	checkRegions(t, st)
+
+	names, err := st.List(ctx, manifest.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 8*4 {
+		t.Fatalf("Listed %d tiles, want %d", len(names), 8*4)
+	}
+	if err := st.Put(ctx, names[0], []byte{1}); err == nil {
+		t.Errorf("Put in an existing pack succeeded")
+	}
+	if err := st.Delete(ctx, names[0]); err == nil {
+		t.Errorf("Delete in an existing pack succeeded")
+	}
+}
+
+func TestPackMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.pack")
+	if st, err := store.Open(context.Background(), "pack://"+path); err == nil {
+		st.Close()
+		t.Fatalf("Opened missing pack %s", path)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Opening missing pack %s created it", path)
+	}
+}
+
+func TestPackCorrupt(t *testing.T) {
+	pack := makePack(t)
+	// Offset of the first index entry after its name
+	entry := 24 + 2 + int(binary.LittleEndian.Uint16(pack[24:]))
+	for _, c := range []struct {
+		name    string
+		corrupt func(p []byte) []byte
+	}{
+		{"empty", func(p []byte) []byte { return nil }},
+		{"truncated header", func(p []byte) []byte { return p[:20] }},
+		{"magic", func(p []byte) []byte { p[0] = 'X'; return p }},
+		{"version", func(p []byte) []byte { p[8] = 9; return p }},
+		{"huge count", func(p []byte) []byte {
+			binary.LittleEndian.PutUint32(p[12:], math.MaxUint32)
+			return p
+		}},
+		{"huge index", func(p []byte) []byte {
+			binary.LittleEndian.PutUint64(p[16:], 1<<40)
+			return p
+		}},
+		{"negative index", func(p []byte) []byte {
+			binary.LittleEndian.PutUint64(p[16:], math.MaxUint64)
+			return p
+		}},
+		{"truncated index", func(p []byte) []byte { return p[:100] }},
+		{"offset beyond the end", func(p []byte) []byte {
+			binary.LittleEndian.PutUint64(p[entry:], 1<<40)
+			return p
+		}},
+		{"length beyond the end", func(p []byte) []byte {
+			binary.LittleEndian.PutUint64(p[entry+8:], 1<<40)
+			return p
+		}},
+		{"truncated tiles", func(p []byte) []byte { return p[:len(p)-1] }},
+	} {
+		path := filepath.Join(t.TempDir(), "corrupt.pack")
+		data := c.corrupt(append([]byte(nil), pack...))
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		st, err := store.Open(context.Background(), "pack://"+path)
+		if err == nil {
+			st.Close()
+			t.Errorf("%s: opened corrupt pack", c.name)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// RangeReader reads byte ranges of a file or object.
+type RangeReader interface {
+	// ReadRange returns the n bytes starting at off.
+	ReadRange(ctx context.Context, off, n int64) ([]byte, error)
+	// Size returns the size of the file or object in bytes.
+	Size(ctx context.Context) (int64, error)
+	Close() error
+}
+
+// CoalesceGap is the largest gap, in bytes, between two ranges read with
+// a single request by ReadRanges. The bytes of the gap are discarded.
+var CoalesceGap int64 = 16 << 10
+
+// ByteRange is a range of n bytes starting at Off.
+type ByteRange struct {
+	Off, N int64
+}
+
+// ReadRanges returns the bytes of each range. Ranges closer than
+// CoalesceGap are read with a single request and the requests are run
+// concurrently.
+func ReadRanges(ctx context.Context, r RangeReader, ranges []ByteRange) ([][]byte, error) {
+	order := make([]int, len(ranges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return ranges[order[i]].Off < ranges[order[j]].Off })
+
+	// Spans of coalesced ranges, as indices of order
+	var spans [][2]int
+	for i := 0; i < len(order); {
+		j := i + 1
+		end := ranges[order[i]].Off + ranges[order[i]].N
+		for ; j < len(order) && ranges[order[j]].Off-end <= CoalesceGap; j++ {
+			if e := ranges[order[j]].Off + ranges[order[j]].N; e > end {
+				end = e
+			}
+		}
+		spans = append(spans, [2]int{i, j})
+		i = j
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	out := make([][]byte, len(ranges))
+	errc := make(chan error, len(spans))
+	var wg sync.WaitGroup
+	for _, span := range spans {
+		wg.Add(1)
+		go func(span [2]int) {
+			defer wg.Done()
+			off, end := ranges[order[span[0]]].Off, int64(0)
+			for _, i := range order[span[0]:span[1]] {
+				if e := ranges[i].Off + ranges[i].N; e > end {
+					end = e
+				}
+			}
+			data, err := r.ReadRange(ctx, off, end-off)
+			if err != nil {
+				errc <- err
+				cancel()
+				return
+			}
+			for _, i := range order[span[0]:span[1]] {
+				// Full slice expression so appending to a range does
+				// not overwrite the next one
+				out[i] = data[ranges[i].Off-off : ranges[i].Off-off+ranges[i].N : ranges[i].Off-off+ranges[i].N]
+			}
+		}(span)
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errc:
+		return nil, err
+	default:
+	}
+	return out, nil
+}
+
+// fileRange reads ranges of a local file.
+type fileRange struct {
+	f *os.File
+}
+
+func (r fileRange) ReadRange(ctx context.Context, off, n int64) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := r.f.ReadAt(data, off); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r fileRange) Size(ctx context.Context) (int64, error) {
+	fi, err := r.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (r fileRange) Close() error { return r.f.Close() }
+
+// httpRange reads ranges of a URL with HTTP range requests.
+type httpRange struct {
+	client *http.Client
+	url    string
+}
+
+// NewHTTPRange returns a RangeReader of the resource at rawurl. The server
+// must support range requests.
+func NewHTTPRange(client *http.Client, rawurl string) RangeReader {
+	return &httpRange{client: client, url: rawurl}
+}
+
+func (r *httpRange) ReadRange(ctx context.Context, off, n int64) ([]byte, error) {
+	if n == 0 {
+		// Ranges can't be empty
+		return []byte{}, nil
+	}
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusNotFound:
+		return nil, ErrNotExist
+	case http.StatusOK:
+		return nil, fmt.Errorf("Range requests not supported by %s", r.url)
+	default:
+		return nil, fmt.Errorf("Failed reading %s: %s", r.url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, n))
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading %s: %v", r.url, err)
+	}
+	if int64(len(data)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// Size returns the Content-Length of a HEAD request.
+func (r *httpRange) Size(ctx context.Context) (int64, error) {
+	req, err := http.NewRequest("HEAD", r.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return 0, ErrNotExist
+	case resp.StatusCode != http.StatusOK:
+		return 0, fmt.Errorf("Failed reading %s: %s", r.url, resp.Status)
+	case resp.ContentLength < 0:
+		return 0, fmt.Errorf("Unknown size of %s", r.url)
+	}
+	return resp.ContentLength, nil
+}
+
+func (r *httpRange) Close() error { return nil }
+
+func init() {
+	for _, scheme := range []string{"http", "https"} {
+		Register("pack+"+scheme, func(ctx context.Context, u *url.URL) (TileStore, error) {
+			v := *u
+			v.Scheme = v.Scheme[len("pack+"):]
+			return OpenPackReader(ctx, NewHTTPRange(http.DefaultClient, v.String()), v.String())
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package store_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prl900/earth_data_server/store"
+	"golang.org/x/net/context"
+)
+
+// rangeServer serves data recording the Range headers of the requests.
+type rangeServer struct {
+	data   []byte
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/data" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "GET" {
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+	}
+	http.ServeContent(w, r, "data", time.Time{}, bytes.NewReader(s.data))
+}
+
+func newRangeServer(t *testing.T) (*rangeServer, *httptest.Server) {
+	s := &rangeServer{data: make([]byte, 1000)}
+	for i := range s.data {
+		s.data[i] = uint8(i % 251)
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func TestReadRanges(t *testing.T) {
+	defer func(gap int64) { store.CoalesceGap = gap }(store.CoalesceGap)
+	store.CoalesceGap = 10
+	s, srv := newRangeServer(t)
+	r := store.NewHTTPRange(srv.Client(), srv.URL+"/data")
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		ranges  []store.ByteRange
+		headers []string
+	}{
+		{[]store.ByteRange{{Off: 100, N: 20}}, []string{"bytes=100-119"}},
+		// A gap of CoalesceGap bytes is read, one more is not
+		{[]store.ByteRange{{Off: 100, N: 20}, {Off: 130, N: 5}}, []string{"bytes=100-134"}},
+		{[]store.ByteRange{{Off: 100, N: 20}, {Off: 131, N: 5}}, []string{"bytes=100-119", "bytes=131-135"}},
+		// Unsorted, overlapping and contained ranges
+		{[]store.ByteRange{{Off: 500, N: 10}, {Off: 0, N: 10}, {Off: 5, N: 10}, {Off: 2, N: 3}, {Off: 505, N: 1}},
+			[]string{"bytes=0-14", "bytes=500-509"}},
+		// Empty ranges aren't requested on their own
+		{[]store.ByteRange{{Off: 50, N: 0}, {Off: 900, N: 0}, {Off: 905, N: 5}}, []string{"bytes=900-909"}},
+		{nil, nil},
+	} {
+		s.ranges = nil
+		data, err := store.ReadRanges(ctx, r, c.ranges)
+		if err != nil {
+			t.Fatalf("Reading %v: %v", c.ranges, err)
+		}
+		if len(data) != len(c.ranges) {
+			t.Fatalf("Reading %v: %d ranges", c.ranges, len(data))
+		}
+		for i, br := range c.ranges {
+			if want := s.data[br.Off : br.Off+br.N]; !bytes.Equal(data[i], want) {
+				t.Errorf("Reading %v: range %d is %v, want %v", c.ranges, i, data[i], want)
+			}
+		}
+		// Spans are requested concurrently
+		sort.Strings(s.ranges)
+		if strings.Join(s.ranges, " ") != strings.Join(c.headers, " ") {
+			t.Errorf("Reading %v: requested %v, want %v", c.ranges, s.ranges, c.headers)
+		}
+	}
+
+	// Appending to a range doesn't overwrite the next one
+	data, err := store.ReadRanges(ctx, r, []store.ByteRange{{Off: 0, N: 5}, {Off: 5, N: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = append(data[0], 0xff)
+	if !bytes.Equal(data[1], s.data[5:10]) {
+		t.Errorf("Appending to a range changed the next one to %v", data[1])
+	}
+
+	if _, err := store.ReadRanges(ctx, r, []store.ByteRange{{Off: 0, N: 10}, {Off: 995, N: 10}}); err == nil {
+		t.Errorf("Read a range beyond the end")
+	}
+	missing := store.NewHTTPRange(srv.Client(), srv.URL+"/missing")
+	if _, err := store.ReadRanges(ctx, missing, []store.ByteRange{{Off: 0, N: 10}}); err != store.ErrNotExist {
+		t.Errorf("Reading a missing resource: %v", err)
+	}
+}
+
+func TestHTTPRange(t *testing.T) {
+	s, srv := newRangeServer(t)
+	r := store.NewHTTPRange(srv.Client(), srv.URL+"/data")
+	ctx := context.Background()
+	if size, err := r.Size(ctx); err != nil || size != int64(len(s.data)) {
+		t.Errorf("Size %d, %v, want %d", size, err, len(s.data))
+	}
+	data, err := r.ReadRange(ctx, 10, 0)
+	if err != nil || len(data) != 0 || len(s.ranges) != 0 {
+		t.Errorf("Reading an empty range: %v, %v with requests %v", data, err, s.ranges)
+	}
+
+	// Servers ignoring ranges
+	full := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(s.data)
+	}))
+	defer full.Close()
+	if _, err := store.NewHTTPRange(full.Client(), full.URL).ReadRange(ctx, 0, 10); err == nil {
+		t.Errorf("Read a range from a server without range requests")
+	}
+}
+
+func TestPackHTTP(t *testing.T) {
+	pack := makePack(t)
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/world.pack" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		http.ServeContent(w, r, "world.pack", time.Time{}, bytes.NewReader(pack))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	st, err := store.Open(ctx, "pack+"+srv.URL+"/world.pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	checkRegions(t, st)
+
+	// The tiles of a row are stored together, read with a single request
+	names, err := st.List(ctx, manifest.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	requests = 0
+	mu.Unlock()
+	data, err := st.(store.MultiGetter).GetMulti(ctx, names[:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names[:4] {
+		want, err := st.Get(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data[i], want) {
+			t.Errorf("Tile %s read together differs", name)
+		}
+	}
+	if requests != 5 {
+		t.Errorf("%d requests reading 4 tiles together and one by one, want 5", requests)
+	}
+
+	if _, err := store.Open(ctx, "pack+"+srv.URL+"/missing.pack"); err == nil {
+		t.Errorf("Opened a missing pack")
+	}
+}
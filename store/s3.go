@@ -0,0 +1,218 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"golang.org/x/net/context"
+)
+
+// s3Store keeps each tile as an object of an S3 compatible bucket.
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 returns a store of the objects under prefix in bucket.
+func NewS3(client *minio.Client, bucket, prefix string) TileStore {
+	return &s3Store{client: client, bucket: bucket, prefix: prefix}
+}
+
+func (s *s3Store) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+func s3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrNotExist
+	}
+	return err
+}
+
+func (s *s3Store) Get(ctx context.Context, name string) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	defer obj.Close()
+
+	// Errors, missing objects included, are returned by the first read
+	data, err := ioutil.ReadAll(obj)
+	if err != nil {
+		if err = s3Error(err); err == ErrNotExist {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Failed reading object: %v", err)
+	}
+	return data, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, name string, data []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(name), bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return fmt.Errorf("Failed to write object to bucket: %v", err)
+	}
+	return nil
+}
+
+func (s *s3Store) Stat(ctx context.Context, name string) (Info, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.key(name), minio.StatObjectOptions{})
+	if err != nil {
+		return Info{}, s3Error(err)
+	}
+	return Info{Name: name, Size: info.Size}, nil
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	root := s.prefix
+	if root != "" {
+		root += "/"
+	}
+	var names []string
+	objs := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: root + prefix, Recursive: true})
+	for obj := range objs {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("Failed listing objects: %v", obj.Err)
+		}
+		names = append(names, strings.TrimPrefix(obj.Key, root))
+	}
+	return names, nil
+}
+
+// Delete removes a tile. S3 does not fail deleting missing objects so the
+// tile is checked first, like the other stores do.
+func (s *s3Store) Delete(ctx context.Context, name string) error {
+	if _, err := s.Stat(ctx, name); err != nil {
+		return err
+	}
+	return s.client.RemoveObject(ctx, s.bucket, s.key(name), minio.RemoveObjectOptions{})
+}
+
+func (s *s3Store) Close() error { return nil }
+
+// s3Range reads ranges of an S3 object.
+type s3Range struct {
+	client      *minio.Client
+	bucket, key string
+}
+
+// NewS3Range returns a RangeReader of an object.
+func NewS3Range(client *minio.Client, bucket, key string) RangeReader {
+	return &s3Range{client: client, bucket: bucket, key: key}
+}
+
+func (r *s3Range) ReadRange(ctx context.Context, off, n int64) ([]byte, error) {
+	var opts minio.GetObjectOptions
+	if err := opts.SetRange(off, off+n-1); err != nil {
+		return nil, err
+	}
+	obj, err := r.client.GetObject(ctx, r.bucket, r.key, opts)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	defer obj.Close()
+
+	data, err := ioutil.ReadAll(obj)
+	if err != nil {
+		if err = s3Error(err); err == ErrNotExist {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Failed reading object: %v", err)
+	}
+	if int64(len(data)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+func (r *s3Range) Size(ctx context.Context) (int64, error) {
+	info, err := r.client.StatObject(ctx, r.bucket, r.key, minio.StatObjectOptions{})
+	if err != nil {
+		return 0, s3Error(err)
+	}
+	return info.Size, nil
+}
+
+func (r *s3Range) Close() error { return nil }
+
+// s3Credentials reads the access keys from the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, or MINIO_ROOT_USER and MINIO_ROOT_PASSWORD,
+// environment variables. Requests are anonymous without keys.
+func s3Credentials() *credentials.Credentials {
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+	})
+}
+
+// s3Options returns the endpoint and client options selected by the query
+// of an s3:// URL: endpoint (host:port or URL, by default AWS_ENDPOINT_URL
+// or s3.amazonaws.com) and region. Buckets are addressed path-style, as
+// expected by MinIO and most S3 compatible servers.
+func s3Options(u *url.URL) (string, *minio.Options, error) {
+	q := u.Query()
+	endpoint := q.Get("endpoint")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	eu, err := url.Parse(endpoint)
+	if err != nil || eu.Host == "" || (eu.Scheme != "http" && eu.Scheme != "https") {
+		return "", nil, fmt.Errorf("Invalid S3 endpoint: %q", endpoint)
+	}
+	region := q.Get("region")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return eu.Host, &minio.Options{
+		Creds:        s3Credentials(),
+		Secure:       eu.Scheme == "https",
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+	}, nil
+}
+
+func init() {
+	Register("s3", func(ctx context.Context, u *url.URL) (TileStore, error) {
+		endpoint, opts, err := s3Options(u)
+		if err != nil {
+			return nil, err
+		}
+		client, err := minio.New(endpoint, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create client: %v", err)
+		}
+		return NewS3(client, u.Host, strings.Trim(u.Path, "/")), nil
+	})
+	Register("pack+s3", func(ctx context.Context, u *url.URL) (TileStore, error) {
+		endpoint, opts, err := s3Options(u)
+		if err != nil {
+			return nil, err
+		}
+		client, err := minio.New(endpoint, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create client: %v", err)
+		}
+		r := NewS3Range(client, u.Host, strings.TrimPrefix(u.Path, "/"))
+		return OpenPackReader(ctx, r, "s3://"+u.Host+u.Path)
+	})
+}
@@ -0,0 +1,54 @@
+package store_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/prl900/earth_data_server/store"
+	"github.com/prl900/earth_data_server/store/s3fake"
+	"golang.org/x/net/context"
+)
+
+// newS3 starts a fake S3 server accepting only the access key set in the
+// environment.
+func newS3(t *testing.T) *s3fake.Server {
+	srv := s3fake.NewServer()
+	t.Cleanup(srv.Close)
+	srv.CreateBucket(bucket)
+	srv.AccessKey = "storetest"
+	t.Setenv("AWS_ACCESS_KEY_ID", srv.AccessKey)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "storetest-secret")
+	return srv
+}
+
+func TestS3Endpoint(t *testing.T) {
+	srv := newS3(t)
+	checkStore(t, "s3://"+bucket+"/endpoint?endpoint="+url.QueryEscape(srv.URL))
+	if objs := srv.Objects(bucket); len(objs) != 8*4 {
+		t.Errorf("%d objects left in the bucket, want %d", len(objs), 8*4)
+	}
+}
+
+func TestS3EndpointURL(t *testing.T) {
+	srv := newS3(t)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	checkStore(t, "s3://"+bucket+"/env")
+}
+
+func TestS3Pack(t *testing.T) {
+	srv := newS3(t)
+	checkPack(t, "s3://"+bucket+"/packed?endpoint="+url.QueryEscape(srv.URL))
+}
+
+func TestS3Credentials(t *testing.T) {
+	srv := newS3(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "someone-else")
+	st, err := store.Open(context.Background(), "s3://"+bucket+"?endpoint="+url.QueryEscape(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := st.Put(context.Background(), "tile", []byte{1}); err == nil {
+		t.Errorf("Put with an unknown access key succeeded")
+	}
+}
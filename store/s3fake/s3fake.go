@@ -0,0 +1,278 @@
+// Package s3fake runs an in-process server emulating the subset of the S3
+// API, with path-style addressing, used by the s3:// tile store, so it can
+// be tested without a MinIO server. Objects are kept in memory.
+package s3fake
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// modTime is the modification time of every object.
+var modTime = time.Date(2004, 12, 1, 0, 0, 0, 0, time.UTC)
+
+// Server is a fake S3 server listening on a local port.
+type Server struct {
+	// URL is the endpoint of the server.
+	URL string
+	// AccessKey, when set, is the only access key accepted. Signatures
+	// are not verified.
+	AccessKey string
+
+	srv     *httptest.Server
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+// NewServer starts a server with no buckets.
+func NewServer() *Server {
+	s := &Server{buckets: map[string]map[string][]byte{}}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// CreateBucket adds an empty bucket.
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[name]; !ok {
+		s.buckets[name] = map[string][]byte{}
+	}
+}
+
+// Objects returns the sorted keys of the objects of a bucket.
+func (s *Server) Objects(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type errorResponse struct {
+	XMLName    xml.Name `xml:"Error"`
+	Code       string
+	Message    string
+	BucketName string `xml:",omitempty"`
+	Key        string `xml:",omitempty"`
+	RequestID  string `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, bucket, key string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == "HEAD" {
+		return
+	}
+	xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: code, BucketName: bucket, Key: key, RequestID: "0"})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// accessKey returns the access key of a request signed with AWS
+// signature version 4, empty for anonymous requests.
+func accessKey(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	i := strings.Index(auth, "Credential=")
+	if i < 0 {
+		return ""
+	}
+	cred := auth[i+len("Credential="):]
+	return cred[:strings.IndexAny(cred+"/", "/,")]
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	// Path-style: /bucket/key
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := p, ""
+	if i := strings.Index(p, "/"); i >= 0 {
+		bucket, key = p[:i], p[i+1:]
+	}
+	if s.AccessKey != "" && accessKey(r) != s.AccessKey {
+		writeError(w, r, http.StatusForbidden, "InvalidAccessKeyId", bucket, key)
+		return
+	}
+	s.mu.Lock()
+	_, ok := s.buckets[bucket]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", bucket, key)
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == "GET" && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r, bucket)
+	case key == "" && r.Method == "GET" && r.URL.Query()["location"] != nil:
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Region  string   `xml:",chardata"`
+		}{})
+	case key == "" && r.Method == "HEAD":
+	case key == "":
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", bucket, key)
+	case r.Method == "GET" || r.Method == "HEAD":
+		s.get(w, r, bucket, key)
+	case r.Method == "PUT":
+		s.put(w, r, bucket, key)
+	case r.Method == "DELETE":
+		s.mu.Lock()
+		delete(s.buckets[bucket], key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", bucket, key)
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.mu.Lock()
+	data, ok := s.buckets[bucket][key]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", bucket, key)
+		return
+	}
+	w.Header().Set("ETag", etag(data))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// ServeContent handles the Range requests
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body = newChunkedReader(r.Body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", bucket, key)
+		return
+	}
+	if n := r.Header.Get("X-Amz-Decoded-Content-Length"); n != "" && n != strconv.Itoa(len(data)) {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", bucket, key)
+		return
+	}
+	s.mu.Lock()
+	s.buckets[bucket][key] = data
+	s.mu.Unlock()
+	w.Header().Set("ETag", etag(data))
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type listResult struct {
+	XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	MaxKeys     int
+	IsTruncated bool
+	Contents    []listEntry
+}
+
+// list answers ListObjectsV2 requests, all the keys in a single page.
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+	res := listResult{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+	s.mu.Lock()
+	for key, data := range s.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			res.Contents = append(res.Contents, listEntry{Key: key, ETag: etag(data), Size: len(data),
+				LastModified: modTime.Format(time.RFC3339), StorageClass: "STANDARD"})
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(res.Contents, func(i, j int) bool { return res.Contents[i].Key < res.Contents[j].Key })
+	res.KeyCount = len(res.Contents)
+	writeXML(w, res)
+}
+
+// chunkedReader decodes the aws-chunked encoding of streaming uploads:
+// chunks of "size;chunk-signature=...\r\n" + data + "\r\n" ending with an
+// empty chunk, optionally followed by trailers.
+type chunkedReader struct {
+	r    *bufio.Reader
+	left int64
+	done bool
+}
+
+func newChunkedReader(r io.Reader) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r)}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.left == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, fmt.Errorf("Invalid chunk header: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			// End of the previous chunk
+			continue
+		}
+		size := line
+		if i := strings.Index(line, ";"); i >= 0 {
+			size = line[:i]
+		}
+		if c.left, err = strconv.ParseInt(size, 16, 64); err != nil {
+			return 0, fmt.Errorf("Invalid chunk size: %q", line)
+		}
+		if c.left == 0 {
+			// Trailers are ignored
+			c.done = true
+			io.Copy(ioutil.Discard, c.r)
+			return 0, io.EOF
+		}
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF && c.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,95 @@
+// Package store reads and writes encoded tiles on different storage
+// backends. Stores are opened from a URL whose scheme selects the backend:
+// file:// for a local directory, pack:// for a single file, mem:// for
+// memory, gs:// for a Google Cloud Storage bucket and s3:// for an S3
+// compatible bucket.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// ErrNotExist is returned when a tile is not in the store.
+var ErrNotExist = errors.New("Tile does not exist")
+
+// Info describes a stored tile.
+type Info struct {
+	Name string
+	Size int64
+}
+
+// TileStore stores encoded tiles by name. Names are slash separated paths
+// relative to the root of the store.
+type TileStore interface {
+	Get(ctx context.Context, name string) ([]byte, error)
+	Put(ctx context.Context, name string, data []byte) error
+	Stat(ctx context.Context, name string) (Info, error)
+	// List returns the sorted names of the tiles starting with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, name string) error
+	Close() error
+}
+
+// MultiGetter is implemented by the stores reading several tiles at once
+// faster than one by one.
+type MultiGetter interface {
+	// GetMulti returns the tiles in the order of names.
+	GetMulti(ctx context.Context, names []string) ([][]byte, error)
+}
+
+// Opener opens the store of a URL.
+type Opener func(ctx context.Context, u *url.URL) (TileStore, error)
+
+var (
+	mu      sync.RWMutex
+	openers = map[string]Opener{}
+)
+
+// Register makes a backend available for the URLs with scheme. It panics
+// if the scheme is already registered.
+func Register(scheme string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := openers[scheme]; dup {
+		panic("store: Register called twice for scheme " + scheme)
+	}
+	openers[scheme] = open
+}
+
+// Schemes returns the sorted URL schemes of the registered backends.
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	schemes := make([]string, 0, len(openers))
+	for scheme := range openers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open returns the store of rawurl. URLs without scheme are local
+// directories.
+func Open(ctx context.Context, rawurl string) (TileStore, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid store URL %q: %v", rawurl, err)
+	}
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = "file"
+	}
+	mu.RLock()
+	open, ok := openers[scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown store scheme %q in %q", scheme, rawurl)
+	}
+	return open(ctx, u)
+}
@@ -0,0 +1,199 @@
+package store_test
+
+import (
+	"image"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/prl900/earth_data_server/store"
+	"github.com/prl900/earth_data_server/tiles"
+	"golang.org/x/net/context"
+)
+
+const (
+	bucket = "bluemarble"
+	format = "snappy"
+)
+
+// manifest describes a small global raster with the tile names of the
+// Blue Marble tiles.
+var manifest = func() *tiles.Manifest {
+	m := tiles.BlueMarble()
+	m.GeoTransform = [6]float64{-180, .5, 0, 90, 0, -.5}
+	m.Width, m.Height, m.TileSize = 720, 360, 100
+	m.Codecs = []string{format}
+	return m
+}()
+
+func pixel(x, y int) uint8 {
+	return uint8((x*3 + y*7) % 251)
+}
+
+// expected returns the expected pixel of a region at x, y, which may lie
+// beyond the antimeridian or the poles.
+func expected(x, y int) uint8 {
+	w, h := manifest.Width, manifest.Height
+	if y < 0 || y >= h {
+		return manifest.NoData
+	}
+	return pixel((x%w+w)%w, y)
+}
+
+func synthetic() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, manifest.Width, manifest.Height))
+	for y := 0; y < manifest.Height; y++ {
+		for x := 0; x < manifest.Width; x++ {
+			img.Pix[y*img.Stride+x] = pixel(x, y)
+		}
+	}
+	return img
+}
+
+// generate puts the tiles of the synthetic image, and their manifest, in
+// st.
+func generate(t *testing.T, st store.TileStore) {
+	t.Helper()
+	ctx := context.Background()
+	if err := manifest.GenerateTiles(ctx, st, synthetic(), 0, format); err != nil {
+		t.Fatalf("Failed generating tiles: %v", err)
+	}
+	if err := tiles.WriteManifest(ctx, st, manifest); err != nil {
+		t.Fatalf("Failed writing manifest: %v", err)
+	}
+}
+
+// checkRegions loads the manifest of st and reads back some regions
+// around the edges of the image.
+func checkRegions(t *testing.T, st store.TileStore) {
+	t.Helper()
+	ctx := context.Background()
+	m, err := tiles.LoadManifest(ctx, st)
+	if err != nil {
+		t.Fatalf("Failed loading manifest: %v", err)
+	}
+	read, err := m.StoreReader(st, format, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []string{"-10,36,4,44", "170,-10,-170,10", "-180,80,180,90", "-190,85,-170,95"} {
+		bbox, err := tiles.ParseBBox(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		im, err := m.MosaicBBox(ctx, bbox, read)
+		if err != nil {
+			t.Fatalf("Failed reading %v: %v", bbox, err)
+		}
+		x0, y0, x1, y1 := bbox.Pixels(m.Grid())
+		if b := im.Bounds(); b.Dx() != x1-x0 || b.Dy() != y1-y0 {
+			t.Fatalf("Unexpected region size for %v: %v", bbox, b)
+		}
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if got, want := im.GrayAt(x-x0, y-y0).Y, expected(x, y); got != want {
+					t.Fatalf("Pixel %d, %d of %v: got %d, want %d", x, y, bbox, got, want)
+				}
+			}
+		}
+	}
+}
+
+// checkObjects tests the Stat, List and Delete methods of st.
+func checkObjects(t *testing.T, st store.TileStore) {
+	t.Helper()
+	ctx := context.Background()
+	names, err := st.List(ctx, manifest.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 8*4 {
+		t.Fatalf("Listed %d tiles, want %d", len(names), 8*4)
+	}
+	info, err := st.Stat(ctx, names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size == 0 {
+		t.Fatalf("Empty tile %s", names[0])
+	}
+	if err := st.Delete(ctx, names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get(ctx, names[0]); err != store.ErrNotExist {
+		t.Fatalf("Reading deleted tile %s: got %v, want %v", names[0], err, store.ErrNotExist)
+	}
+	if _, err := st.Stat(ctx, names[0]); err != store.ErrNotExist {
+		t.Fatalf("Stat of deleted tile %s: got %v, want %v", names[0], err, store.ErrNotExist)
+	}
+}
+
+// checkStore writes the tiles of the synthetic image to the store at
+// storeURL, reads them back and deletes one.
+func checkStore(t *testing.T, storeURL string) {
+	t.Helper()
+	st, err := store.Open(context.Background(), storeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	generate(t, st)
+	checkRegions(t, st)
+	checkObjects(t, st)
+}
+
+func TestMem(t *testing.T) {
+	checkStore(t, "mem://storetest")
+}
+
+func TestFS(t *testing.T) {
+	checkStore(t, "file://"+filepath.Join(t.TempDir(), "tiles"))
+}
+
+// makePack returns a pack of the tiles of the synthetic image.
+func makePack(t *testing.T) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "world.pack")
+	st, err := store.CreatePack(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generate(t, st)
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkPack uploads a pack to the store at storeURL and reads it back with
+// ranged reads from its pack+ URL.
+func checkPack(t *testing.T, storeURL string) {
+	t.Helper()
+	ctx := context.Background()
+	st, err := store.Open(ctx, storeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := st.Put(ctx, "world.pack", makePack(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Scheme = "pack+" + u.Scheme
+	u.Path += "/world.pack"
+	packed, err := store.Open(ctx, u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packed.Close()
+	checkRegions(t, packed)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prl900/earth_data_server/codec"
+	"github.com/prl900/earth_data_server/store"
+	"github.com/prl900/earth_data_server/tiles"
+	"golang.org/x/net/context"
+)
+
+// readChannels decodes a PNG image and splits it in channels.
+func readChannels(path string) ([]*image.Gray, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding %s: %v", path, err)
+	}
+	return tiles.GetChannels(img)
+}
+
+// createStore opens the store the tiles are written to. pack:// URLs
+// create a new pack, replacing the existing one once complete.
+func createStore(ctx context.Context, storeURL string) (store.TileStore, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid store URL %q: %v", storeURL, err)
+	}
+	if p := u.Host + u.Path; u.Scheme == "pack" && p != "" {
+		return store.CreatePack(p)
+	}
+	return store.Open(ctx, storeURL)
+}
+
+// addToCatalog writes the manifest of the dataset, with the URL of its
+// store, in the catalog directory. Local paths are made absolute so they
+// don't depend on where the catalog is.
+func addToCatalog(dir, storeURL string, m *tiles.Manifest) error {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return err
+	}
+	if p := u.Host + u.Path; (u.Scheme == "" || u.Scheme == "file" || u.Scheme == "pack") && !filepath.IsAbs(p) {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		scheme := u.Scheme
+		if scheme == "" {
+			scheme = "file"
+		}
+		storeURL = scheme + "://" + abs
+	}
+	entry := *m
+	entry.Store = storeURL
+	data, err := json.MarshalIndent(&entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, m.Name+".json"), append(data, '\n'), 0644)
+}
+
+func main() {
+	src := flag.String("src", "world.topo.bathy.200412.3x21600x10800.png", "Source RGB image, or comma separated list of the images of a time series")
+	times := flag.String("times", "", "Comma separated dates of the source images, such as 200401,200402")
+	storeURL := flag.String("store", "file://.", "URL of the tile store: "+strings.Join(store.Schemes(), "://, ")+"://")
+	codecs := flag.String("codec", "snappy", "Comma separated list of tile codecs, the first being the default: "+strings.Join(codec.Names(), ", "))
+	overviews := flag.Bool("overviews", true, "Generate the overview levels")
+	aggregation := flag.String("aggregation", "mean", "Overview aggregation: "+strings.Join(tiles.Aggregations(), ", "))
+	name := flag.String("name", "world.topo.bathy.200412.3x400x400", "Dataset name, prefix of the tile names")
+	extent := flag.String("extent", "-180,-90,180,90", "Extent of the image as minLon,minLat,maxLon,maxLat")
+	tileSize := flag.Int("tilesize", 400, "Tile size in pixels")
+	bands := flag.String("bands", "red,green,blue", "Comma separated names of the image channels")
+	catalog := flag.String("catalog", "", "Directory of manifests where the dataset is added as NAME.json")
+	flag.Parse()
+
+	bbox, err := tiles.ParseBBox(*extent)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srcs := strings.Split(*src, ",")
+	var dates []string
+	if *times != "" {
+		for _, s := range strings.Split(*times, ",") {
+			t, err := tiles.ParseTime(s)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dates = append(dates, t.Format(tiles.TimeLayout))
+		}
+	}
+	if len(dates) > 0 && len(dates) != len(srcs) {
+		log.Fatalf("Got %d times for %d source images", len(dates), len(srcs))
+	}
+	if len(dates) == 0 && len(srcs) > 1 {
+		log.Fatalf("Got %d source images without -times", len(srcs))
+	}
+
+	// The manifest takes the size of the first image, the others must
+	// match it
+	channs, err := readChannels(srcs[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	b := channs[0].Bounds()
+	m := &tiles.Manifest{
+		Name:         *name,
+		CRS:          "EPSG:4326",
+		GeoTransform: bbox.GeoTransform(b.Dx(), b.Dy()).GDAL(),
+		Width:        b.Dx(),
+		Height:       b.Dy(),
+		TileSize:     *tileSize,
+		Bands:        strings.Split(*bands, ","),
+		DType:        "uint8",
+		Codecs:       strings.Split(*codecs, ","),
+		Levels:       1,
+		TileName:     *name + ".%02d.%02d.%s",
+		OverviewName: *name + ".l%d.%02d.%02d.%s",
+		Times:        dates,
+	}
+	if len(m.Bands) != len(channs) {
+		log.Fatalf("Got %d band names for %d channels", len(m.Bands), len(channs))
+	}
+	if *overviews {
+		m.Levels = m.NumLevels()
+	}
+	if err := m.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	st, err := createStore(ctx, *storeURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for t, path := range srcs {
+		if t > 0 {
+			if channs, err = readChannels(path); err != nil {
+				log.Fatal(err)
+			}
+		}
+		mt := m
+		if len(m.Times) > 0 {
+			mt = m.At(t)
+			fmt.Printf("Generating %s tiles from %s\n", m.Times[t], path)
+		}
+		for _, c := range m.Codecs {
+			for i, chann := range channs {
+				start := time.Now()
+				if err := mt.GenerateTiles(ctx, st, chann, i, c); err != nil {
+					log.Fatal(err)
+				}
+				if err := mt.GenerateOverviews(ctx, st, chann, i, c, *aggregation); err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("Generating %s %s tiles: %v\n", m.Bands[i], c, time.Since(start))
+			}
+		}
+	}
+	if err := tiles.WriteManifest(ctx, st, m); err != nil {
+		log.Fatal(err)
+	}
+	// Packs are written when closed
+	if err := st.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if *catalog != "" {
+		if err := addToCatalog(*catalog, *storeURL, m); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Printf("Generated %d levels of %dx%d tiles\n", m.Levels, m.TileSize, m.TileSize)
+}
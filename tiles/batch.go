@@ -0,0 +1,106 @@
+package tiles
+
+import (
+	"image"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// regionKey is the context key of the region read by a mosaic.
+type regionKey struct{}
+
+// region lists the tiles of a level a mosaic is about to read, so the
+// readers of stores able to read several tiles at once fetch them together
+// on the first read.
+type region struct {
+	level int
+	tiles []image.Point
+
+	mu      sync.Mutex
+	batches map[interface{}]*batch
+	subsets map[interface{}]*region
+}
+
+// batch holds the encoded tiles of a region read by one reader.
+type batch struct {
+	once sync.Once
+	mu   sync.Mutex
+	data map[image.Point][]byte
+	err  error
+}
+
+func newRegion(level int) *region {
+	return &region{level: level, batches: map[interface{}]*batch{}, subsets: map[interface{}]*region{}}
+}
+
+func withRegion(ctx context.Context, level int, jobs []tileJob) context.Context {
+	rg := newRegion(level)
+	seen := map[image.Point]bool{}
+	for _, job := range jobs {
+		pt := image.Pt(job.tileC, job.tileR)
+		if !seen[pt] {
+			seen[pt] = true
+			rg.tiles = append(rg.tiles, pt)
+		}
+	}
+	return context.WithValue(ctx, regionKey{}, rg)
+}
+
+func regionFrom(ctx context.Context) *region {
+	rg, _ := ctx.Value(regionKey{}).(*region)
+	return rg
+}
+
+// subset returns the region of the tiles of rg for which keep is true.
+// It is computed on the first call by the reader src, later calls getting
+// the same region so its tiles are still fetched together.
+func (rg *region) subset(src interface{}, keep func(pt image.Point) bool) *region {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	sub, ok := rg.subsets[src]
+	if !ok {
+		sub = newRegion(rg.level)
+		for _, pt := range rg.tiles {
+			if keep(pt) {
+				sub.tiles = append(sub.tiles, pt)
+			}
+		}
+		rg.subsets[src] = sub
+	}
+	return sub
+}
+
+// get returns the encoded tile at pt of the region, fetching all the tiles
+// with fetch the first time it is called by the reader src. It returns
+// false if the tile is not part of the region.
+func (rg *region) get(src interface{}, pt image.Point, fetch func(pts []image.Point) ([][]byte, error)) ([]byte, bool, error) {
+	rg.mu.Lock()
+	b, ok := rg.batches[src]
+	if !ok {
+		b = &batch{}
+		rg.batches[src] = b
+	}
+	rg.mu.Unlock()
+
+	b.once.Do(func() {
+		data, err := fetch(rg.tiles)
+		if err != nil {
+			b.err = err
+			return
+		}
+		b.data = make(map[image.Point][]byte, len(data))
+		for i, pt := range rg.tiles {
+			b.data[pt] = data[i]
+		}
+	})
+	if b.err != nil {
+		return nil, true, b.err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.data[pt]
+	// Each tile is read once, free it
+	delete(b.data, pt)
+	return data, ok, nil
+}
@@ -0,0 +1,92 @@
+package tiles
+
+import (
+	"image"
+	"sync"
+	"testing"
+
+	"github.com/prl900/earth_data_server/store"
+	"golang.org/x/net/context"
+)
+
+// countingStore records the tiles fetched from a store.
+type countingStore struct {
+	store.TileStore
+	mu    sync.Mutex
+	gets  []string
+	multi [][]string
+}
+
+func (s *countingStore) Get(ctx context.Context, name string) ([]byte, error) {
+	s.mu.Lock()
+	s.gets = append(s.gets, name)
+	s.mu.Unlock()
+	return s.TileStore.Get(ctx, name)
+}
+
+func (s *countingStore) GetMulti(ctx context.Context, names []string) ([][]byte, error) {
+	s.mu.Lock()
+	s.multi = append(s.multi, names)
+	s.mu.Unlock()
+	data := make([][]byte, len(names))
+	for i, name := range names {
+		var err error
+		if data[i], err = s.TileStore.Get(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func TestCachedRegionBatch(t *testing.T) {
+	ctx := context.Background()
+	m := testManifest(90, 45, true)
+	mem, err := store.Open(ctx, "mem://batchtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	img := image.NewGray(image.Rect(0, 0, m.Width, m.Height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % 251)
+	}
+	if err := m.GenerateTiles(ctx, mem, img, 0, "raw"); err != nil {
+		t.Fatal(err)
+	}
+	st := &countingStore{TileStore: mem}
+	read, err := m.StoreReader(st, "raw", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read = NewCache(1<<20).Reader(m.Name, 0, read)
+
+	for _, c := range []struct {
+		rect  image.Rectangle
+		multi int
+	}{
+		{image.Rect(0, 0, 32, 16), 2},
+		// Only the third tile is missing from the cache
+		{image.Rect(0, 0, 48, 16), 1},
+		{image.Rect(0, 0, 48, 16), 0},
+	} {
+		st.multi, st.gets = nil, nil
+		im, err := m.MosaicRect(ctx, 0, c.rect, read)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < c.rect.Dy(); y++ {
+			for x := 0; x < c.rect.Dx(); x++ {
+				if got, want := im.GrayAt(x, y).Y, img.GrayAt(x, y).Y; got != want {
+					t.Fatalf("Pixel %d, %d of %v: got %d, want %d", x, y, c.rect, got, want)
+				}
+			}
+		}
+		n := 0
+		for _, names := range st.multi {
+			n += len(names)
+		}
+		if len(st.multi) > 1 || n != c.multi || len(st.gets) != 0 {
+			t.Errorf("Reading %v fetched %v in batches and %v alone, want %d tiles in one batch", c.rect, st.multi, st.gets, c.multi)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BBox is a geographic bounding box in degrees.
+type BBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// ParseBBox parses a "minLon,minLat,maxLon,maxLat" string.
+func ParseBBox(s string) (BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BBox{}, fmt.Errorf("Invalid bbox %q: expecting minLon,minLat,maxLon,maxLat", s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return BBox{}, fmt.Errorf("Invalid bbox %q: %v", s, err)
+		}
+		v[i] = f
+	}
+	b := BBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if b.MinLon > b.MaxLon {
+		// The box crosses the antimeridian
+		b.MaxLon += 360
+	}
+	return b, b.Validate()
+}
+
+// Validate checks that the box is finite and not empty. Boxes crossing
+// the antimeridian extend beyond 180 degrees of longitude and boxes
+// extending beyond the poles are padded with NoData, but a box can't span
+// more than the whole globe.
+func (b BBox) Validate() error {
+	for _, v := range []float64{b.MinLon, b.MinLat, b.MaxLon, b.MaxLat} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("Non finite bbox: %v", b)
+		}
+	}
+	if b.MinLon >= b.MaxLon || b.MinLat >= b.MaxLat {
+		return fmt.Errorf("Empty bbox: %v", b)
+	}
+	if b.MaxLon-b.MinLon > 360 || b.MaxLat-b.MinLat > 180 {
+		return fmt.Errorf("Bbox larger than the globe: %v", b)
+	}
+	if b.MinLon < -540 || b.MaxLon > 540 || b.MinLat < -270 || b.MaxLat > 270 {
+		return fmt.Errorf("Bbox out of range [-540,-270,540,270]: %v", b)
+	}
+	return nil
+}
+
+func (b BBox) String() string {
+	return fmt.Sprintf("%v,%v,%v,%v", b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+}
+
+// window returns the fractional pixel coordinates of the box in the
+// raster with geotransform g.
+func (b BBox) window(g GeoTransform) (x0, y0, x1, y1 float64) {
+	x0, y0 = g.Pixel(b.MinLon, b.MaxLat)
+	x1, y1 = g.Pixel(b.MaxLon, b.MinLat)
+	return snap(x0), snap(y0), snap(x1), snap(y1)
+}
+
+// Pixels returns the window of the raster with geotransform g covered by
+// the box. Pixels partially covered by the box are included.
+func (b BBox) Pixels(g GeoTransform) (x0, y0, x1, y1 int) {
+	fx0, fy0, fx1, fy1 := b.window(g)
+	return int(math.Floor(fx0)), int(math.Floor(fy0)), int(math.Ceil(fx1)), int(math.Ceil(fy1))
+}
+
+// sourceWindow returns the pixels read to sample the box from the raster
+// with geotransform g, and the window of the box relative to them. Along
+// the axes where the box is narrower than the snapping of pixel edges, the
+// window isn't snapped so the box still covers the pixels containing it.
+func (b BBox) sourceWindow(g GeoTransform) (rect image.Rectangle, x0, y0, x1, y1 float64) {
+	x0, y0, x1, y1 = b.window(g)
+	if x1 <= x0 {
+		x0, _ = g.Pixel(b.MinLon, 0)
+		x1, _ = g.Pixel(b.MaxLon, 0)
+	}
+	if y1 <= y0 {
+		_, y0 = g.Pixel(0, b.MaxLat)
+		_, y1 = g.Pixel(0, b.MinLat)
+	}
+	rect = image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+	// Boxes narrower than the precision of the coordinates
+	if rect.Dx() == 0 {
+		rect.Max.X++
+	}
+	if rect.Dy() == 0 {
+		rect.Max.Y++
+	}
+	fx, fy := float64(rect.Min.X), float64(rect.Min.Y)
+	return rect, x0 - fx, y0 - fy, x1 - fx, y1 - fy
+}
+
+// GeoTransform returns the geotransform of the box sampled with
+// width x height pixels.
+func (b BBox) GeoTransform(width, height int) GeoTransform {
+	return GeoTransform{OriginX: b.MinLon, OriginY: b.MaxLat,
+		PixelWidth:  (b.MaxLon - b.MinLon) / float64(width),
+		PixelHeight: (b.MinLat - b.MaxLat) / float64(height)}
+}
@@ -0,0 +1,32 @@
+package tiles
+
+import "testing"
+
+func TestParseBBox(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want BBox
+		ok   bool
+	}{
+		{"-10,36,4,44", BBox{MinLon: -10, MinLat: 36, MaxLon: 4, MaxLat: 44}, true},
+		{"170,-10,-170,10", BBox{MinLon: 170, MinLat: -10, MaxLon: 190, MaxLat: 10}, true},
+		{"0,80,10,100", BBox{MinLon: 0, MinLat: 80, MaxLon: 10, MaxLat: 100}, true},
+		{"0,0,10", BBox{}, false},
+		{"0,10,10,0", BBox{}, false},
+		{"0,0,0,10", BBox{}, false},
+		{"-180,-90,190,90", BBox{}, false},
+		{"NaN,0,10,10", BBox{}, false},
+		{"0,0,10,NaN", BBox{}, false},
+		{"-Inf,0,10,10", BBox{}, false},
+		{"0,0,+Inf,10", BBox{}, false},
+	} {
+		b, err := ParseBBox(c.s)
+		if (err == nil) != c.ok {
+			t.Errorf("ParseBBox(%q) error %v, want ok %v", c.s, err, c.ok)
+			continue
+		}
+		if c.ok && b != c.want {
+			t.Errorf("ParseBBox(%q) = %v, want %v", c.s, b, c.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package tiles
+
+import (
+	"container/list"
+	"image"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// TileKey identifies a decoded tile in a Cache.
+type TileKey struct {
+	Dataset         string
+	Level, Col, Row int
+	Band            int
+}
+
+// CacheStats reports the usage of a Cache. Misses include the lookups
+// served by sharing the fetch of another request.
+type CacheStats struct {
+	Hits, Misses int64
+	Tiles        int
+	Bytes        int64
+}
+
+type cacheEntry struct {
+	key  TileKey
+	tile *image.Gray
+}
+
+// call is a fetch in flight, shared by all the requests of the same tile.
+type call struct {
+	done chan struct{}
+	tile *image.Gray
+	err  error
+}
+
+// Cache is an LRU cache of decoded tiles bounded by the size of their
+// pixels. Concurrent requests of a tile missing from the cache share a
+// single fetch. The tiles returned are shared and must not be modified.
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	stats    CacheStats
+	ll       *list.List
+	items    map[TileKey]*list.Element
+	calls    map[TileKey]*call
+}
+
+// NewCache returns a cache holding up to maxBytes of pixels.
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{maxBytes: maxBytes, ll: list.New(),
+		items: map[TileKey]*list.Element{}, calls: map[TileKey]*call{}}
+}
+
+// Stats returns the hit and miss counters and the current size.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Get returns the tile for key, calling fetch to obtain it when it's not
+// in the cache and no other fetch of the same tile is in flight.
+func (c *Cache) Get(ctx context.Context, key TileKey, fetch func(ctx context.Context) (*image.Gray, error)) (*image.Gray, error) {
+	for {
+		c.mu.Lock()
+		if e, ok := c.items[key]; ok {
+			c.ll.MoveToFront(e)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return e.Value.(*cacheEntry).tile, nil
+		}
+		c.stats.Misses++
+		if cl, ok := c.calls[key]; ok {
+			c.mu.Unlock()
+			select {
+			case <-cl.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if cl.err != nil && isCancel(cl.err) && ctx.Err() == nil {
+				// The request leading the fetch was cancelled, not this one
+				continue
+			}
+			return cl.tile, cl.err
+		}
+		cl := &call{done: make(chan struct{})}
+		c.calls[key] = cl
+		c.mu.Unlock()
+
+		cl.tile, cl.err = fetch(ctx)
+
+		c.mu.Lock()
+		delete(c.calls, key)
+		if cl.err == nil {
+			cl.tile = compact(cl.tile)
+			c.add(key, cl.tile)
+		}
+		c.mu.Unlock()
+		close(cl.done)
+		return cl.tile, cl.err
+	}
+}
+
+func isCancel(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded
+}
+
+// compact returns a copy of tile holding just its pixels, so the cache
+// doesn't keep alive the buffers tiles are decoded from, such as the spans
+// read from a pack.
+func compact(tile *image.Gray) *image.Gray {
+	b := tile.Bounds()
+	dst := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		copy(dst.Pix[dst.PixOffset(b.Min.X, y):], tile.Pix[tile.PixOffset(b.Min.X, y):tile.PixOffset(b.Max.X, y)])
+	}
+	return dst
+}
+
+// add inserts a tile evicting the least recently used ones. c.mu must be
+// held.
+func (c *Cache) add(key TileKey, tile *image.Gray) {
+	size := int64(len(tile.Pix))
+	if size > c.maxBytes {
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key, tile})
+	c.stats.Tiles++
+	c.stats.Bytes += size
+	for c.stats.Bytes > c.maxBytes {
+		e := c.ll.Back()
+		entry := c.ll.Remove(e).(*cacheEntry)
+		delete(c.items, entry.key)
+		c.stats.Tiles--
+		c.stats.Bytes -= int64(len(entry.tile.Pix))
+	}
+}
+
+// contains reports whether the tile of key is in the cache, without
+// counting a lookup.
+func (c *Cache) contains(key TileKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+// Reader wraps read so the tiles of band of dataset are served from the
+// cache. Readers fetching the tiles of a mosaic together are only asked
+// for the tiles missing from the cache.
+func (c *Cache) Reader(dataset string, band int, read TileReader) TileReader {
+	// Identifies the regions narrowed by this reader
+	src := new(byte)
+	return func(ctx context.Context, level, tileC, tileR int) (*image.Gray, error) {
+		key := TileKey{Dataset: dataset, Level: level, Col: tileC, Row: tileR, Band: band}
+		return c.Get(ctx, key, func(ctx context.Context) (*image.Gray, error) {
+			if rg := regionFrom(ctx); rg != nil && rg.level == level {
+				missing := rg.subset(src, func(pt image.Point) bool {
+					return !c.contains(TileKey{Dataset: dataset, Level: level, Col: pt.X, Row: pt.Y, Band: band})
+				})
+				ctx = context.WithValue(ctx, regionKey{}, missing)
+			}
+			return read(ctx, level, tileC, tileR)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package tiles
+
+import (
+	"errors"
+	"image"
+	"runtime"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fetchTile returns a fetch of a size x size tile counting its calls.
+func fetchTile(calls *int, size int) func(ctx context.Context) (*image.Gray, error) {
+	return func(ctx context.Context) (*image.Gray, error) {
+		*calls++
+		return image.NewGray(image.Rect(0, 0, size, size)), nil
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	ctx := context.Background()
+	// Room for three 16x16 tiles
+	c := NewCache(3 * 256)
+	key := func(col int) TileKey { return TileKey{Dataset: "test", Col: col} }
+	calls := 0
+	for _, col := range []int{0, 1, 2, 0, 3} {
+		if _, err := c.Get(ctx, key(col), fetchTile(&calls, 16)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Tile 1 was the least recently used when tile 3 was added
+	for col, want := range []bool{true, false, true, true} {
+		if got := c.contains(key(col)); got != want {
+			t.Errorf("Tile %d cached: %v, want %v", col, got, want)
+		}
+	}
+	want := CacheStats{Hits: 1, Misses: 4, Tiles: 3, Bytes: 3 * 256}
+	if s := c.Stats(); calls != 4 || s != want {
+		t.Errorf("%d fetches and stats %+v, want 4 and %+v", calls, s, want)
+	}
+	// Tile 0 is still cached, tile 1 is fetched again
+	if _, err := c.Get(ctx, key(0), fetchTile(&calls, 16)); err != nil || calls != 4 {
+		t.Errorf("Fetched cached tile 0: %v", err)
+	}
+	if _, err := c.Get(ctx, key(1), fetchTile(&calls, 16)); err != nil || calls != 5 {
+		t.Errorf("Didn't fetch evicted tile 1: %v", err)
+	}
+}
+
+func TestCacheBudget(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(1000)
+	calls := 0
+	// Larger than the whole cache, never cached
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(ctx, TileKey{Dataset: "large"}, fetchTile(&calls, 32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := c.Stats(); calls != 2 || s.Tiles != 0 || s.Bytes != 0 {
+		t.Errorf("%d fetches and stats %+v caching a tile beyond the budget", calls, s)
+	}
+	// Evicting as many tiles as needed to fit a larger one
+	for col := 0; col < 10; col++ {
+		c.Get(ctx, TileKey{Dataset: "small", Col: col}, fetchTile(&calls, 10))
+	}
+	c.Get(ctx, TileKey{Dataset: "medium"}, fetchTile(&calls, 25))
+	if s := c.Stats(); s.Tiles != 4 || s.Bytes != 925 {
+		t.Errorf("Stats %+v, want 4 tiles of 925 bytes", s)
+	}
+	for col := 0; col < 10; col++ {
+		if got, want := c.contains(TileKey{Dataset: "small", Col: col}), col >= 7; got != want {
+			t.Errorf("Small tile %d cached: %v, want %v", col, got, want)
+		}
+	}
+
+	// Failed fetches aren't cached
+	fail := errors.New("failed")
+	_, err := c.Get(ctx, TileKey{Dataset: "failed"}, func(ctx context.Context) (*image.Gray, error) { return nil, fail })
+	if err != fail || c.contains(TileKey{Dataset: "failed"}) {
+		t.Errorf("Failed fetch: %v", err)
+	}
+}
+
+// Tiles decoded from a larger buffer are cached without it.
+func TestCacheCompact(t *testing.T) {
+	buf := make([]byte, 4096)
+	for i := range buf {
+		buf[i] = uint8(i)
+	}
+	tile := &image.Gray{Pix: buf[1000:1256], Stride: 16, Rect: image.Rect(0, 0, 16, 16)}
+	c := NewCache(1 << 20)
+	got, err := c.Get(context.Background(), TileKey{}, func(ctx context.Context) (*image.Gray, error) { return tile, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cap(got.Pix) != 256 || got.Stride != 16 || got.Rect != tile.Rect {
+		t.Fatalf("Cached tile of %d bytes of capacity, stride %d and bounds %v", cap(got.Pix), got.Stride, got.Rect)
+	}
+	for i, v := range got.Pix {
+		if v != tile.Pix[i] {
+			t.Fatalf("Pixel %d is %d, want %d", i, v, tile.Pix[i])
+		}
+	}
+}
+
+// Concurrent requests of a missing tile share a single fetch.
+func TestCacheSharedFetch(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(1 << 20)
+	const n = 10
+	var (
+		mu      sync.Mutex
+		calls   int
+		wg      sync.WaitGroup
+		results [n]*image.Gray
+	)
+	started, release := make(chan struct{}), make(chan struct{})
+	fetch := func(ctx context.Context) (*image.Gray, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		close(started)
+		<-release
+		return image.NewGray(image.Rect(0, 0, 16, 16)), nil
+	}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tile, err := c.Get(ctx, TileKey{Dataset: "shared"}, fetch)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = tile
+		}(i)
+		if i == 0 {
+			<-started
+		}
+	}
+	// Wait for the other requests to join the fetch in flight
+	for c.Stats().Misses != n {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("%d fetches, want 1", calls)
+	}
+	for i, tile := range results {
+		if tile == nil || tile != results[0] {
+			t.Errorf("Request %d got tile %p, want %p", i, tile, results[0])
+		}
+	}
+
+	// A request cancelled while waiting doesn't wait for the fetch
+	started, release = make(chan struct{}), make(chan struct{})
+	go c.Get(ctx, TileKey{Dataset: "slow"}, fetch)
+	<-started
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Get(cctx, TileKey{Dataset: "slow"}, fetch); err != context.Canceled {
+		t.Errorf("Cancelled request: %v", err)
+	}
+	close(release)
+}
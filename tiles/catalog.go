@@ -0,0 +1,122 @@
+package tiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prl900/earth_data_server/store"
+	"golang.org/x/net/context"
+)
+
+// Dataset is a manifest with the store holding its tiles.
+type Dataset struct {
+	Name     string
+	Manifest *Manifest
+	Store    store.TileStore
+	// StoreURL is the URL Store was opened from.
+	StoreURL string
+}
+
+// Close closes the store of the dataset.
+func (d *Dataset) Close() error {
+	return d.Store.Close()
+}
+
+// OpenDataset opens the store at storeURL and loads its manifest. Stores
+// without manifest, such as the tiles generated by part 2, are read as the
+// Blue Marble image, named as in part 3 in Google Cloud Storage buckets.
+func OpenDataset(ctx context.Context, storeURL string) (*Dataset, error) {
+	st, err := store.Open(ctx, storeURL)
+	if err != nil {
+		return nil, err
+	}
+	m, err := LoadManifest(ctx, st)
+	if err == store.ErrNotExist {
+		m, err = BlueMarble(), nil
+		if strings.HasPrefix(storeURL, "gs://") {
+			m = BlueMarbleGCS()
+		}
+	}
+	if err != nil {
+		st.Close()
+		return nil, fmt.Errorf("Failed loading manifest of %s: %v", storeURL, err)
+	}
+	return &Dataset{Name: m.Name, Manifest: m, Store: st, StoreURL: storeURL}, nil
+}
+
+// LoadCatalog opens the datasets of the manifests in the directory dir,
+// one NAME.json file per dataset named NAME. The store of each dataset is
+// given by the manifest; relative file:// and pack:// paths are relative
+// to dir.
+func LoadCatalog(ctx context.Context, dir string) ([]*Dataset, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var datasets []*Dataset
+	for _, p := range paths {
+		d, err := loadCatalogEntry(ctx, dir, p)
+		if err != nil {
+			for _, d := range datasets {
+				d.Close()
+			}
+			return nil, fmt.Errorf("Failed loading %s: %v", p, err)
+		}
+		datasets = append(datasets, d)
+	}
+	return datasets, nil
+}
+
+func loadCatalogEntry(ctx context.Context, dir, p string) (*Dataset, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if m.Store == "" {
+		return nil, fmt.Errorf("Missing store URL")
+	}
+	storeURL, err := catalogURL(dir, m.Store)
+	if err != nil {
+		return nil, err
+	}
+	st, err := store.Open(ctx, storeURL)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(p), ".json")
+	return &Dataset{Name: name, Manifest: m, Store: st, StoreURL: storeURL}, nil
+}
+
+// catalogURL resolves the relative local paths of a store URL against the
+// catalog directory.
+func catalogURL(dir, rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", fmt.Errorf("Invalid store URL %q: %v", rawurl, err)
+	}
+	switch u.Scheme {
+	case "", "file", "pack":
+	default:
+		return rawurl, nil
+	}
+	// file://tiles is the relative directory tiles
+	p := u.Host + u.Path
+	if filepath.IsAbs(p) {
+		return rawurl, nil
+	}
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = "file"
+	}
+	return scheme + "://" + filepath.Join(dir, p), nil
+}
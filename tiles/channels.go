@@ -0,0 +1,32 @@
+package tiles
+
+import (
+	"fmt"
+	"image"
+)
+
+// GetChannels splits an RGBA image into its red, green and blue bands.
+func GetChannels(img image.Image) ([]*image.Gray, error) {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported image type %T, expecting *image.RGBA", img)
+	}
+	rect := rgba.Bounds()
+	ch1 := make([]byte, rect.Dx()*rect.Dy())
+	ch2 := make([]byte, rect.Dx()*rect.Dy())
+	ch3 := make([]byte, rect.Dx()*rect.Dy())
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			i := y*rect.Dx() + x
+			p := y*rgba.Stride + x*4
+			ch1[i] = rgba.Pix[p]
+			ch2[i] = rgba.Pix[p+1]
+			ch3[i] = rgba.Pix[p+2]
+			// Alpha channel is discarded (opaque image)
+		}
+	}
+	red := image.Gray{Pix: ch1, Stride: rect.Dx(), Rect: rect}
+	green := image.Gray{Pix: ch2, Stride: rect.Dx(), Rect: rect}
+	blue := image.Gray{Pix: ch3, Stride: rect.Dx(), Rect: rect}
+	return []*image.Gray{&red, &green, &blue}, nil
+}
@@ -0,0 +1,58 @@
+// Package tiles splits rasters, such as the Blue Marble image, into tiles
+// and reads regions back by stitching the tiles together. The geometry of
+// each dataset is described by its Manifest.
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/prl900/earth_data_server/codec"
+	"github.com/prl900/earth_data_server/store"
+	"golang.org/x/net/context"
+)
+
+// GenerateTiles encodes a single band into TileSize x TileSize tiles with
+// the codec registered as codecName and puts them in st.
+func (m *Manifest) GenerateTiles(ctx context.Context, st store.TileStore, img *image.Gray, band int, codecName string) error {
+	return m.generateLevel(ctx, st, img, 0, band, codecName)
+}
+
+func (m *Manifest) generateLevel(ctx context.Context, st store.TileStore, img *image.Gray, level, band int, codecName string) error {
+	if band < 0 || band >= len(m.Bands) {
+		return fmt.Errorf("Invalid band: %d", band)
+	}
+	c, err := codec.Get(codecName)
+	if err != nil {
+		return err
+	}
+	width, height := m.LevelSize(level)
+	b := img.Bounds()
+	if b.Dx() != width || b.Dy() != height {
+		return fmt.Errorf("Unexpected image size for level %d: %dx%d, expecting %dx%d",
+			level, b.Dx(), b.Dy(), width, height)
+	}
+	size := m.TileSize
+	// Row by row, so packed tiles of a region are close to each other
+	for j := 0; j*size < height; j++ {
+		for i := 0; i*size < width; i++ {
+			rect := image.Rect(i*size, j*size, (i+1)*size, (j+1)*size).Add(b.Min)
+			tile := img.SubImage(rect).(*image.Gray)
+			if !tile.Rect.Eq(rect) {
+				// Edge tile, pad to the full tile size
+				padded := image.NewGray(image.Rect(0, 0, size, size))
+				draw.Draw(padded, tile.Rect.Sub(rect.Min), tile, tile.Rect.Min, draw.Src)
+				tile = padded
+			}
+			data, err := c.Encode(tile)
+			if err != nil {
+				return err
+			}
+			if err := st.Put(ctx, m.tileKey(level, i, j, band, c), data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
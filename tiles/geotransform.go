@@ -0,0 +1,55 @@
+package tiles
+
+import (
+	"fmt"
+	"math"
+)
+
+// GeoTransform is the affine transformation between pixel and geographic
+// coordinates of a north up image, as in GDAL. Pixel x, y (0, 0 being the
+// top left corner of the top left pixel) maps to:
+//
+//	lon = OriginX + x*PixelWidth
+//	lat = OriginY + y*PixelHeight
+//
+// PixelHeight is negative as rows go from north to south.
+type GeoTransform struct {
+	OriginX, OriginY        float64
+	PixelWidth, PixelHeight float64
+}
+
+// Pixel returns the fractional pixel coordinates of lon, lat.
+func (g GeoTransform) Pixel(lon, lat float64) (x, y float64) {
+	return (lon - g.OriginX) / g.PixelWidth, (lat - g.OriginY) / g.PixelHeight
+}
+
+// Coord returns the geographic coordinates of the pixel coordinates x, y.
+// The centre of pixel i, j is at x = i+.5, y = j+.5.
+func (g GeoTransform) Coord(x, y float64) (lon, lat float64) {
+	return g.OriginX + x*g.PixelWidth, g.OriginY + y*g.PixelHeight
+}
+
+// Sub returns the geotransform of the window starting at pixel x0, y0.
+func (g GeoTransform) Sub(x0, y0 int) GeoTransform {
+	lon, lat := g.Coord(float64(x0), float64(y0))
+	return GeoTransform{OriginX: lon, OriginY: lat, PixelWidth: g.PixelWidth, PixelHeight: g.PixelHeight}
+}
+
+// GDAL returns the six coefficients in the order used by GDAL.
+func (g GeoTransform) GDAL() [6]float64 {
+	return [6]float64{g.OriginX, g.PixelWidth, 0, g.OriginY, 0, g.PixelHeight}
+}
+
+func (g GeoTransform) String() string {
+	c := g.GDAL()
+	return fmt.Sprintf("%v,%v,%v,%v,%v,%v", c[0], c[1], c[2], c[3], c[4], c[5])
+}
+
+// snap absorbs the rounding errors of the transformation so coordinates
+// falling on pixel edges aren't shifted by one pixel when truncated.
+func snap(v float64) float64 {
+	if r := math.Round(v); math.Abs(v-r) < 1e-6 {
+		return r
+	}
+	return v
+}
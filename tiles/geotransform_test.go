@@ -0,0 +1,82 @@
+package tiles
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeoTransform(t *testing.T) {
+	for _, g := range []GeoTransform{
+		{OriginX: -180, OriginY: 90, PixelWidth: 4, PixelHeight: -4},
+		// Pixel sizes not representable in binary
+		{OriginX: -180, OriginY: 90, PixelWidth: 360. / 43200, PixelHeight: -180. / 21600},
+		{OriginX: 112.9, OriginY: -10.1, PixelWidth: .1, PixelHeight: -.05},
+	} {
+		for _, p := range [][2]float64{{0, 0}, {.25, .75}, {.5, .5}, {1, 2}, {9.999, 3.001}, {12345.5, 6789.25}, {-.5, -1}} {
+			lon, lat := g.Coord(p[0], p[1])
+			x, y := g.Pixel(lon, lat)
+			if math.Abs(x-p[0]) > 1e-9 || math.Abs(y-p[1]) > 1e-9 {
+				t.Errorf("%v: pixel %v at %v, %v maps back to %v, %v", g, p, lon, lat, x, y)
+			}
+		}
+		// Latitudes decrease down the rows
+		if _, lat := g.Coord(.5, .5); lat >= g.OriginY || lat <= g.OriginY+g.PixelHeight {
+			t.Errorf("%v: centre of the first pixel at latitude %v", g, lat)
+		}
+	}
+
+	g := GeoTransform{OriginX: -180, OriginY: 90, PixelWidth: .5, PixelHeight: -.25}
+	if x, y := g.Pixel(-179.875, 89.875); x != .25 || y != .5 {
+		t.Errorf("Pixel of -179.875, 89.875 is %v, %v, want .25, .5", x, y)
+	}
+	if lon, lat := g.Coord(1.5, 2.5); lon != -179.25 || lat != 89.375 {
+		t.Errorf("Centre of pixel 1, 2 at %v, %v, want -179.25, 89.375", lon, lat)
+	}
+	sub := g.Sub(4, 8)
+	if want := (GeoTransform{OriginX: -178, OriginY: 88, PixelWidth: .5, PixelHeight: -.25}); sub != want {
+		t.Errorf("Sub(4, 8) = %v, want %v", sub, want)
+	}
+	if x, y := sub.Pixel(g.Coord(5.5, 10.5)); x != 1.5 || y != 2.5 {
+		t.Errorf("Pixel 5.5, 10.5 is %v, %v in the window at 4, 8", x, y)
+	}
+	if c := g.GDAL(); c != [6]float64{-180, .5, 0, 90, 0, -.25} {
+		t.Errorf("GDAL() = %v", c)
+	}
+	if s := g.String(); s != "-180,0.5,0,90,0,-0.25" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestSnap(t *testing.T) {
+	for _, c := range []struct {
+		v, want float64
+	}{
+		{3, 3},
+		{2.9999999, 3},
+		{3.0000001, 3},
+		{-1e-7, 0},
+		{2.999, 2.999},
+		{.5, .5},
+		// Beyond the tolerance
+		{3 - 2e-6, 3 - 2e-6},
+		{3 + 2e-6, 3 + 2e-6},
+	} {
+		if got := snap(c.v); got != c.want {
+			t.Errorf("snap(%v) = %v, want %v", c.v, got, c.want)
+		}
+	}
+
+	// Edges of pixels of .1 degrees aren't exact in floating point
+	g := GeoTransform{OriginX: 0, OriginY: 0, PixelWidth: .1, PixelHeight: -.1}
+	for i := 0; i <= 100; i++ {
+		lon, lat := float64(i)*.1, -float64(i)*.1
+		x, y := g.Pixel(lon, lat)
+		if sx, sy := snap(x), snap(y); sx != float64(i) || sy != float64(i) {
+			t.Errorf("Edge %d at %v, %v is pixel %v, %v (%v, %v snapped)", i, lon, lat, x, y, sx, sy)
+		}
+		b := BBox{MinLon: lon, MinLat: -10, MaxLon: 10, MaxLat: lat}
+		if x0, y0, _, _ := b.window(g); x0 != float64(i) || y0 != float64(i) {
+			t.Errorf("Window of %v starts at %v, %v, want %d, %d", b, x0, y0, i, i)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package tiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/prl900/earth_data_server/codec"
+	"github.com/prl900/earth_data_server/store"
+	"golang.org/x/net/context"
+)
+
+// ManifestName is the name of the manifest in a store.
+const ManifestName = "manifest.json"
+
+// Manifest describes a tiled dataset: the geometry of the raster, how it
+// is split in tiles and how the tiles are encoded and named. The tiler
+// writes it along the tiles and readers load it from the store.
+type Manifest struct {
+	Name string `json:"name"`
+	// CRS of the geotransform, only EPSG:4326 is supported.
+	CRS string `json:"crs"`
+	// GeoTransform of the full resolution raster, in GDAL order.
+	GeoTransform [6]float64 `json:"geotransform"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	TileSize     int        `json:"tile_size"`
+	Bands        []string   `json:"bands"`
+	// DType is the type of the pixels, only uint8 is supported.
+	DType string `json:"dtype"`
+	// Codecs the tiles are encoded with, the first one being the default.
+	Codecs []string `json:"codecs"`
+	NoData uint8    `json:"nodata"`
+	// Levels is the number of overview levels, including the full
+	// resolution.
+	Levels int `json:"levels"`
+	// TileName formats the name of the full resolution tiles from their
+	// column, row and band name. OverviewName adds the level before the
+	// column.
+	TileName     string `json:"tile_name"`
+	OverviewName string `json:"overview_name"`
+	// Extensions overrides the extension of the tiles of some codecs,
+	// appended to their names after a dot. Tiles of the codecs mapped to
+	// an empty extension are named without one.
+	Extensions map[string]string `json:"extensions,omitempty"`
+	// Times are the dates of the rasters of a time series, formatted
+	// with TimeLayout, in increasing order. Empty if the dataset has a
+	// single raster.
+	Times []string `json:"times,omitempty"`
+	// Store is the URL of the tile store of the manifests of a catalog.
+	Store string `json:"store,omitempty"`
+}
+
+// BlueMarble returns the manifest of the tiles generated by the part 2
+// scripts, which have no manifest.
+func BlueMarble() *Manifest {
+	return &Manifest{
+		Name:         "world.topo.bathy.200412",
+		CRS:          "EPSG:4326",
+		GeoTransform: [6]float64{-180, 1. / 60, 0, 90, 0, -1. / 60},
+		Width:        21600,
+		Height:       10800,
+		TileSize:     400,
+		Bands:        []string{"red", "green", "blue"},
+		DType:        "uint8",
+		Codecs:       []string{"snappy", "raw", "png"},
+		NoData:       0,
+		Levels:       1,
+		TileName:     "world.topo.bathy.200412.3x400x400.%02d.%02d.%s",
+		OverviewName: "world.topo.bathy.200412.3x400x400.l%d.%02d.%02d.%s",
+	}
+}
+
+// BlueMarbleGCS returns the manifest of the objects uploaded by the part 3
+// scripts: the snappy tiles of BlueMarble, named without extension.
+func BlueMarbleGCS() *Manifest {
+	m := BlueMarble()
+	m.Codecs = []string{"snappy"}
+	m.Extensions = map[string]string{"snappy": ""}
+	return m
+}
+
+// Validate checks that the manifest describes a dataset that can be read.
+func (m *Manifest) Validate() error {
+	switch {
+	case m.CRS != "EPSG:4326":
+		return fmt.Errorf("Unsupported CRS: %q", m.CRS)
+	case m.DType != "uint8":
+		return fmt.Errorf("Unsupported dtype: %q", m.DType)
+	case m.GeoTransform[1] <= 0 || m.GeoTransform[5] >= 0 || m.GeoTransform[2] != 0 || m.GeoTransform[4] != 0:
+		return fmt.Errorf("Unsupported geotransform, expecting north up: %v", m.GeoTransform)
+	case m.Width <= 0 || m.Height <= 0 || m.TileSize <= 0:
+		return fmt.Errorf("Invalid raster size %dx%d or tile size %d", m.Width, m.Height, m.TileSize)
+	case len(m.Bands) == 0:
+		return fmt.Errorf("No bands")
+	case len(m.Codecs) == 0:
+		return fmt.Errorf("No codecs")
+	case m.Levels < 1 || m.Levels > m.NumLevels():
+		return fmt.Errorf("Invalid number of levels %d, expecting 1 to %d", m.Levels, m.NumLevels())
+	case strings.Count(m.TileName, "%") != 3 || strings.Count(m.OverviewName, "%") != 4:
+		return fmt.Errorf("Invalid tile names %q, %q", m.TileName, m.OverviewName)
+	}
+	for _, name := range m.Codecs {
+		if _, err := codec.Get(name); err != nil {
+			return err
+		}
+	}
+	for name, ext := range m.Extensions {
+		if _, err := codec.Get(name); err != nil {
+			return err
+		}
+		if strings.ContainsAny(ext, "./") {
+			return fmt.Errorf("Invalid extension %q of codec %s", ext, name)
+		}
+	}
+	return m.validateTimes()
+}
+
+// Grid returns the geotransform of the full resolution raster.
+func (m *Manifest) Grid() GeoTransform {
+	g := m.GeoTransform
+	return GeoTransform{OriginX: g[0], OriginY: g[3], PixelWidth: g[1], PixelHeight: g[5]}
+}
+
+// LevelGrid returns the geotransform of an overview level. The pixels of
+// levels whose size was rounded up by LevelSize are slightly smaller than
+// twice those of the previous level, so every level covers the extent of
+// the raster and global levels wrap exactly at the antimeridian.
+func (m *Manifest) LevelGrid(level int) GeoTransform {
+	g := m.Grid()
+	width, height := m.LevelSize(level)
+	return GeoTransform{OriginX: g.OriginX, OriginY: g.OriginY,
+		PixelWidth:  g.PixelWidth * float64(m.Width) / float64(width),
+		PixelHeight: g.PixelHeight * float64(m.Height) / float64(height)}
+}
+
+// Global reports whether the raster covers every longitude, so regions
+// wrap around the antimeridian.
+func (m *Manifest) Global() bool {
+	return math.Abs(float64(m.Width)*m.GeoTransform[1]-360) < 1e-6
+}
+
+// BBox returns the extent of the raster.
+func (m *Manifest) BBox() BBox {
+	g := m.Grid()
+	minLon, maxLat := g.Coord(0, 0)
+	maxLon, minLat := g.Coord(float64(m.Width), float64(m.Height))
+	return BBox{MinLon: minLon, MinLat: minLat, MaxLon: maxLon, MaxLat: maxLat}
+}
+
+// Band returns the index of a band, which can be given by name or index.
+func (m *Manifest) Band(name string) (int, error) {
+	for i, b := range m.Bands {
+		if b == name || fmt.Sprint(i) == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown band %q, expecting one of: %s", name, strings.Join(m.Bands, ", "))
+}
+
+// objectName returns the name of a tile without extension.
+func (m *Manifest) objectName(level, tileC, tileR, band int) string {
+	if level == 0 {
+		return fmt.Sprintf(m.TileName, tileC, tileR, m.Bands[band])
+	}
+	return fmt.Sprintf(m.OverviewName, level, tileC, tileR, m.Bands[band])
+}
+
+// tileKey returns the name in a store of a tile encoded with c.
+func (m *Manifest) tileKey(level, tileC, tileR, band int, c codec.Codec) string {
+	ext, ok := m.Extensions[c.Name()]
+	if !ok {
+		ext = c.Ext()
+	}
+	if ext == "" {
+		return m.objectName(level, tileC, tileR, band)
+	}
+	return m.objectName(level, tileC, tileR, band) + "." + ext
+}
+
+// LoadManifest reads the manifest of the dataset kept in st. It returns
+// store.ErrNotExist when there is no manifest.
+func LoadManifest(ctx context.Context, st store.TileStore) (*Manifest, error) {
+	data, err := st.Get(ctx, ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(data)
+}
+
+// ParseManifest decodes and validates a JSON manifest.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("Invalid manifest: %v", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid manifest: %v", err)
+	}
+	return m, nil
+}
+
+// WriteManifest puts the manifest in st.
+func WriteManifest(ctx context.Context, st store.TileStore, m *Manifest) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return st.Put(ctx, ManifestName, append(data, '\n'))
+}
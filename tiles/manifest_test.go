@@ -0,0 +1,53 @@
+package tiles
+
+import (
+	"testing"
+
+	"github.com/prl900/earth_data_server/codec"
+)
+
+func TestTileKey(t *testing.T) {
+	custom := BlueMarble()
+	custom.Extensions = map[string]string{"raw": "bin"}
+	for _, c := range []struct {
+		m                   *Manifest
+		codec               string
+		level, tileC, tileR int
+		band                int
+		want                string
+	}{
+		{BlueMarble(), "snappy", 0, 3, 10, 0, "world.topo.bathy.200412.3x400x400.03.10.red.snpy"},
+		{BlueMarble(), "png", 2, 1, 0, 2, "world.topo.bathy.200412.3x400x400.l2.01.00.blue.png"},
+		{BlueMarbleGCS(), "snappy", 0, 3, 10, 1, "world.topo.bathy.200412.3x400x400.03.10.green"},
+		{custom, "raw", 0, 0, 0, 0, "world.topo.bathy.200412.3x400x400.00.00.red.bin"},
+		{custom, "snappy", 0, 0, 0, 0, "world.topo.bathy.200412.3x400x400.00.00.red.snpy"},
+	} {
+		cd, err := codec.Get(c.codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.m.tileKey(c.level, c.tileC, c.tileR, c.band, cd); got != c.want {
+			t.Errorf("tileKey(%d, %d, %d, %d, %s) = %q, want %q", c.level, c.tileC, c.tileR, c.band, c.codec, got, c.want)
+		}
+	}
+}
+
+func TestValidateExtensions(t *testing.T) {
+	for _, c := range []struct {
+		exts map[string]string
+		ok   bool
+	}{
+		{nil, true},
+		{map[string]string{"snappy": ""}, true},
+		{map[string]string{"raw": "bin"}, true},
+		{map[string]string{"nope": ""}, false},
+		{map[string]string{"raw": ".raw"}, false},
+		{map[string]string{"raw": "a/b"}, false},
+	} {
+		m := BlueMarble()
+		m.Extensions = c.exts
+		if err := m.Validate(); (err == nil) != c.ok {
+			t.Errorf("Validate with extensions %v: %v, want ok %v", c.exts, err, c.ok)
+		}
+	}
+}
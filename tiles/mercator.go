@@ -0,0 +1,87 @@
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/net/context"
+)
+
+// MaxMercatorLat is the latitude of the top edge of the Web Mercator
+// (EPSG:3857) square, the bottom edge being at -MaxMercatorLat.
+const MaxMercatorLat = 85.0511287798066
+
+// MaxMercatorZoom is the deepest zoom level of Web Mercator tiles.
+const MaxMercatorZoom = 30
+
+// mercatorLat returns the latitude of the fractional row y of the Web
+// Mercator tiles of zoom level z, 0 being the top edge.
+func mercatorLat(z int, y float64) float64 {
+	n := math.Exp2(float64(z))
+	return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+}
+
+// MercatorBBox returns the extent of the Web Mercator tile x, y of zoom
+// level z.
+func MercatorBBox(z, x, y int) (BBox, error) {
+	if z < 0 || z > MaxMercatorZoom {
+		return BBox{}, fmt.Errorf("Invalid zoom level %d, expecting [0, %d]", z, MaxMercatorZoom)
+	}
+	n := 1 << uint(z)
+	if x < 0 || x >= n || y < 0 || y >= n {
+		return BBox{}, fmt.Errorf("Tile %d/%d/%d out of range", z, x, y)
+	}
+	deg := 360 / float64(n)
+	return BBox{MinLon: -180 + float64(x)*deg, MaxLon: -180 + float64(x+1)*deg,
+		MinLat: mercatorLat(z, float64(y+1)), MaxLat: mercatorLat(z, float64(y))}, nil
+}
+
+// MosaicMercator reprojects the Web Mercator tile x, y of zoom level z to
+// size x size pixels, taking the nearest pixel of the coarsest overview
+// level that still has the resolution of the highest latitude row of the
+// tile.
+func (m *Manifest) MosaicMercator(ctx context.Context, z, x, y, size int, read TileReader) (*image.Gray, error) {
+	bbox, err := MercatorBBox(z, x, y)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("Invalid tile size: %d", size)
+	}
+	// Rows span cos(lat) times the degrees of the columns, the finest
+	// being the sampled row nearest to a pole
+	lat := math.Max(math.Abs(mercatorLat(z, float64(y)+.5/float64(size))),
+		math.Abs(mercatorLat(z, float64(y+1)-.5/float64(size))))
+	lat = math.Min(lat, MaxMercatorLat)
+	level := m.levelFor(float64(size) / (bbox.MaxLon - bbox.MinLon) / math.Cos(lat*math.Pi/180))
+
+	g := m.LevelGrid(level)
+	rect, _, _, _, _ := bbox.sourceWindow(g)
+	canvas, err := m.MosaicRect(ctx, level, rect, read)
+	if err != nil {
+		return nil, err
+	}
+	x0, y0 := rect.Min.X, rect.Min.Y
+
+	// Columns are evenly spaced in longitude, rows follow the Mercator
+	// latitudes
+	cols := make([]int, size)
+	for i := range cols {
+		lon := bbox.MinLon + (float64(i)+.5)*(bbox.MaxLon-bbox.MinLon)/float64(size)
+		px, _ := g.Pixel(lon, 0)
+		cols[i] = clamp(int(math.Floor(px))-x0, rect.Dx())
+	}
+	out := image.NewGray(image.Rect(0, 0, size, size))
+	for j := 0; j < size; j++ {
+		lat := mercatorLat(z, float64(y)+(float64(j)+.5)/float64(size))
+		_, py := g.Pixel(0, lat)
+		row := clamp(int(math.Floor(py))-y0, rect.Dy())
+		src := canvas.Pix[row*canvas.Stride:]
+		dst := out.Pix[j*out.Stride:]
+		for i, c := range cols {
+			dst[i] = src[c]
+		}
+	}
+	return out, nil
+}
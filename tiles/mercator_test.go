@@ -0,0 +1,79 @@
+package tiles
+
+import (
+	"image"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestMosaicMercatorLevel(t *testing.T) {
+	m := testManifest(2880, 1440, true)
+	for _, c := range []struct {
+		z, x, y, size int
+		level         int
+	}{
+		{0, 0, 0, 16, 4},
+		{2, 0, 0, 16, 2},
+		{2, 1, 1, 16, 4},
+		{3, 5, 3, 16, 4},
+		{3, 2, 4, 32, 3},
+		{4, 3, 1, 16, 0},
+	} {
+		var mu sync.Mutex
+		levels := map[int]bool{}
+		read := valueReader(m, row)
+		im, err := m.MosaicMercator(context.Background(), c.z, c.x, c.y, c.size,
+			func(ctx context.Context, level, tileC, tileR int) (*image.Gray, error) {
+				mu.Lock()
+				levels[level] = true
+				mu.Unlock()
+				return read(ctx, level, tileC, tileR)
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !levels[c.level] || len(levels) != 1 {
+			t.Errorf("Tile %d/%d/%d read levels %v, want %d", c.z, c.x, c.y, levels, c.level)
+		}
+		// Each row of the tile samples a different row of the level
+		for j := 1; j < c.size; j++ {
+			if im.GrayAt(0, j).Y == im.GrayAt(0, j-1).Y {
+				t.Errorf("Tile %d/%d/%d repeats the pixels of row %d in row %d", c.z, c.x, c.y, j-1, j)
+				break
+			}
+		}
+	}
+}
+
+func TestMosaicMercatorMaxZoom(t *testing.T) {
+	// Tiles of the deepest zoom level are much narrower than the pixels
+	// and fall on their edges at the equator and the antimeridian
+	m := testManifest(90, 45, true)
+	n := 1 << uint(MaxMercatorZoom)
+	for _, c := range []struct {
+		x, y     int
+		col, row int
+	}{
+		{0, 0, 0, 1},
+		{n / 2, n / 2, 45, 22},
+		{n/2 - 1, n/2 - 1, 44, 22},
+		{n - 1, n - 1, 89, 43},
+	} {
+		for _, v := range []struct {
+			val  func(x, y int) uint8
+			want int
+		}{{column, c.col}, {row, c.row}} {
+			im, err := m.MosaicMercator(context.Background(), MaxMercatorZoom, c.x, c.y, 16, valueReader(m, v.val))
+			if err != nil {
+				t.Fatalf("Tile %d/%d/%d: %v", MaxMercatorZoom, c.x, c.y, err)
+			}
+			for i, p := range im.Pix {
+				if int(p) != v.want {
+					t.Fatalf("Tile %d/%d/%d: pixel %d is %d, want %d", MaxMercatorZoom, c.x, c.y, i, p, v.want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sync"
+
+	"github.com/prl900/earth_data_server/codec"
+	"github.com/prl900/earth_data_server/store"
+	"golang.org/x/net/context"
+)
+
+// TileReader returns the pixels of the tile at column tileC, row tileR of
+// an overview level, 0 being the full resolution. Readers are called
+// concurrently by the mosaic functions.
+type TileReader func(ctx context.Context, level, tileC, tileR int) (*image.Gray, error)
+
+// Workers bounds the number of tiles fetched and decoded concurrently by
+// each mosaic.
+var Workers = 8
+
+// WindowSize is the size of the window returned by Mosaic.
+const WindowSize = 400
+
+// StoreReader returns a TileReader for the tiles of a band kept in st and
+// encoded with the codec registered as codecName. When st is a
+// store.MultiGetter, the tiles of a mosaic are fetched with a single call.
+func (m *Manifest) StoreReader(st store.TileStore, codecName string, band int) (TileReader, error) {
+	if band < 0 || band >= len(m.Bands) {
+		return nil, fmt.Errorf("Invalid band: %d", band)
+	}
+	c, err := codec.Get(codecName)
+	if err != nil {
+		return nil, err
+	}
+	mg, batched := st.(store.MultiGetter)
+	// Identifies the batches of this reader, not zero sized to be unique
+	src := new(byte)
+	return func(ctx context.Context, level, tileC, tileR int) (*image.Gray, error) {
+		if rg := regionFrom(ctx); batched && rg != nil && rg.level == level {
+			// The first read of a mosaic fetches all its tiles, but for
+			// the ones Cache.Reader left out of the region as cached
+			data, ok, err := rg.get(src, image.Pt(tileC, tileR), func(pts []image.Point) ([][]byte, error) {
+				names := make([]string, len(pts))
+				for i, pt := range pts {
+					names[i] = m.tileKey(level, pt.X, pt.Y, band, c)
+				}
+				return mg.GetMulti(ctx, names)
+			})
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return c.Decode(data, m.TileSize, m.TileSize)
+			}
+		}
+		data, err := st.Get(ctx, m.tileKey(level, tileC, tileR, band, c))
+		if err != nil {
+			return nil, err
+		}
+		return c.Decode(data, m.TileSize, m.TileSize)
+	}, nil
+}
+
+// tileJob copies the pixels of a tile starting at src into the dst
+// rectangle of the canvas.
+type tileJob struct {
+	tileC, tileR int
+	dst          image.Rectangle
+	src          image.Point
+}
+
+// MosaicRect stitches the pixels of an overview level inside rect from
+// every tile intersecting it. The result has its origin at 0, 0. Columns
+// outside the level wrap around the antimeridian in global rasters and
+// pixels outside the raster, such as rows beyond the poles, are filled
+// with NoData.
+func (m *Manifest) MosaicRect(ctx context.Context, level int, rect image.Rectangle, read TileReader) (*image.Gray, error) {
+	if rect.Empty() {
+		return nil, fmt.Errorf("Empty region %v", rect)
+	}
+	width, height := m.LevelSize(level)
+	canvas := image.NewGray(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	if m.NoData != 0 {
+		draw.Draw(canvas, canvas.Rect, image.NewUniform(color.Gray{m.NoData}), image.Point{}, draw.Src)
+	}
+
+	var jobs []tileJob
+	rows := rect.Intersect(image.Rect(rect.Min.X, 0, rect.Max.X, height))
+	if !m.Global() {
+		rows = rows.Intersect(image.Rect(0, 0, width, height))
+	}
+	for x := rows.Min.X; x < rows.Max.X; {
+		// Part of rect within one turn around the globe
+		turn := floorDiv(x, width)
+		seg := image.Rect(x, rows.Min.Y, minInt(rows.Max.X, (turn+1)*width), rows.Max.Y)
+		jobs = m.appendJobs(jobs, seg.Min.Sub(rect.Min), seg.Sub(image.Pt(turn*width, 0)))
+		x = seg.Max.X
+	}
+	if err := fetchTiles(ctx, canvas, level, jobs, read); err != nil {
+		return nil, err
+	}
+	return canvas, nil
+}
+
+// appendJobs adds the tiles needed to draw the pixels of the level inside
+// rect, which must lie within the level bounds, on the canvas at off.
+func (m *Manifest) appendJobs(jobs []tileJob, off image.Point, rect image.Rectangle) []tileJob {
+	size := m.TileSize
+	for tileR := rect.Min.Y / size; tileR*size < rect.Max.Y; tileR++ {
+		for tileC := rect.Min.X / size; tileC*size < rect.Max.X; tileC++ {
+			tileRect := image.Rect(tileC*size, tileR*size, (tileC+1)*size, (tileR+1)*size)
+			isect := tileRect.Intersect(rect)
+			jobs = append(jobs, tileJob{tileC: tileC, tileR: tileR,
+				dst: isect.Sub(rect.Min).Add(off), src: isect.Min.Sub(tileRect.Min)})
+		}
+	}
+	return jobs
+}
+
+// fetchTiles draws the tiles of jobs in canvas.
+func fetchTiles(ctx context.Context, canvas *image.Gray, level int, jobs []tileJob, read TileReader) error {
+	return readTiles(ctx, level, jobs, read, func(job tileJob, tile *image.Gray) {
+		// Jobs draw on disjoint rectangles of the canvas
+		draw.Draw(canvas, job.dst, tile, job.src, draw.Src)
+	})
+}
+
+// readTiles runs the jobs on a pool of Workers goroutines, passing the
+// tile of each one to use. The first error cancels the tiles still being
+// fetched.
+func readTiles(ctx context.Context, level int, jobs []tileJob, read TileReader, use func(job tileJob, tile *image.Gray)) error {
+	ctx, cancel := context.WithCancel(withRegion(ctx, level, jobs))
+	defer cancel()
+
+	jobc := make(chan tileJob)
+	errc := make(chan error, 1)
+	var wg sync.WaitGroup
+	for w := 0; w < Workers && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobc {
+				tile, err := read(ctx, level, job.tileC, job.tileR)
+				if err != nil {
+					select {
+					case errc <- fmt.Errorf("Failed reading tile %d.%02d.%02d: %v", level, job.tileC, job.tileR, err):
+					default:
+					}
+					cancel()
+					continue
+				}
+				use(job, tile)
+			}
+		}()
+	}
+
+feed:
+	for _, job := range jobs {
+		select {
+		case jobc <- job:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobc)
+	wg.Wait()
+
+	select {
+	case err := <-errc:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// MosaicWindow returns the WindowSize x WindowSize window of the full
+// resolution raster centred on the pixel nearest to lat, lon.
+func (m *Manifest) MosaicWindow(lat, lon float64) image.Rectangle {
+	x, y := m.Grid().Pixel(lon, lat)
+	i := int(math.Floor(snap(x) + .5))
+	j := int(math.Floor(snap(y) + .5))
+	return image.Rect(i-WindowSize/2, j-WindowSize/2, i+WindowSize/2, j+WindowSize/2)
+}
+
+// Mosaic stitches the WindowSize x WindowSize region centred on lat, lon
+// from the tiles returned by read. The geotransform of the result is
+// m.Grid().Sub(win.Min.X, win.Min.Y), win being m.MosaicWindow(lat, lon).
+func (m *Manifest) Mosaic(ctx context.Context, lat, lon float64, read TileReader) (*image.Gray, error) {
+	return m.MosaicRect(ctx, 0, m.MosaicWindow(lat, lon), read)
+}
+
+// MosaicBBox stitches the pixels covered by bbox from the tiles returned
+// by read. As partially covered pixels are included the geotransform of
+// the result is m.Grid().Sub(x0, y0), x0, y0 being returned by
+// bbox.Pixels(m.Grid()).
+func (m *Manifest) MosaicBBox(ctx context.Context, bbox BBox, read TileReader) (*image.Gray, error) {
+	if err := bbox.Validate(); err != nil {
+		return nil, err
+	}
+	x0, y0, x1, y1 := bbox.Pixels(m.Grid())
+	return m.MosaicRect(ctx, 0, image.Rect(x0, y0, x1, y1), read)
+}
+
+// bboxLevel returns the coarsest overview level with the resolution of
+// bbox resampled to width x height.
+func (m *Manifest) bboxLevel(bbox BBox, width, height int) int {
+	return m.levelFor(math.Max(float64(width)/(bbox.MaxLon-bbox.MinLon),
+		float64(height)/(bbox.MaxLat-bbox.MinLat)))
+}
+
+// BBoxSource returns the overview level and its pixels read by
+// MosaicBBoxSize to resample bbox to width x height. Without overviews
+// they are the full resolution pixels whatever the size.
+func (m *Manifest) BBoxSource(bbox BBox, width, height int) (level int, rect image.Rectangle) {
+	level = m.bboxLevel(bbox, width, height)
+	rect, _, _, _, _ = bbox.sourceWindow(m.LevelGrid(level))
+	return level, rect
+}
+
+// MosaicBBoxSize returns the region covered by bbox resampled to
+// width x height with the named resampling method. The region is read
+// from the coarsest overview level that still has the requested
+// resolution. The geotransform of the result is
+// bbox.GeoTransform(width, height).
+func (m *Manifest) MosaicBBoxSize(ctx context.Context, bbox BBox, width, height int, method string, read TileReader) (*image.Gray, error) {
+	if _, ok := resamplers[method]; !ok {
+		return nil, fmt.Errorf("Unknown resampling method: %q", method)
+	}
+	if err := bbox.Validate(); err != nil {
+		return nil, err
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("Invalid output size: %dx%d", width, height)
+	}
+	level := m.bboxLevel(bbox, width, height)
+	rect, x0, y0, x1, y1 := bbox.sourceWindow(m.LevelGrid(level))
+	canvas, err := m.MosaicRect(ctx, level, rect, read)
+	if err != nil {
+		return nil, err
+	}
+	return resampleWindow(canvas, x0, y0, x1, y1, width, height, method)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,283 @@
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// testManifest returns the manifest of a width x height raster in 16x16
+// tiles with a complete pyramid, covering the whole globe or, if not
+// global, a box of one degree pixels at its north west corner.
+func testManifest(width, height int, global bool) *Manifest {
+	m := &Manifest{Name: "global", CRS: "EPSG:4326",
+		GeoTransform: [6]float64{-180, 360 / float64(width), 0, 90, 0, -180 / float64(height)},
+		Width:        width, Height: height, TileSize: 16, Bands: []string{"gray"},
+		DType: "uint8", Codecs: []string{"raw"}, NoData: 255}
+	if !global {
+		m.Name = "regional"
+		m.GeoTransform = [6]float64{-180, 1, 0, 90, 0, -1}
+	}
+	m.Levels = m.NumLevels()
+	return m
+}
+
+// valueReader returns the tiles of a raster whose pixel x, y of every
+// level has the value val(x, y). Tiles on the edges are padded with zeros
+// and reading tiles outside the level fails.
+func valueReader(m *Manifest, val func(x, y int) uint8) TileReader {
+	return func(ctx context.Context, level, tileC, tileR int) (*image.Gray, error) {
+		width, height := m.LevelSize(level)
+		size := m.TileSize
+		if tileC < 0 || tileR < 0 || tileC*size >= width || tileR*size >= height {
+			return nil, fmt.Errorf("Tile %d.%02d.%02d outside the level", level, tileC, tileR)
+		}
+		tile := image.NewGray(image.Rect(0, 0, size, size))
+		for j := 0; j < size && tileR*size+j < height; j++ {
+			for i := 0; i < size && tileC*size+i < width; i++ {
+				tile.Pix[j*tile.Stride+i] = val(tileC*size+i, tileR*size+j)
+			}
+		}
+		return tile, nil
+	}
+}
+
+func column(x, y int) uint8 { return uint8(x) }
+
+func row(x, y int) uint8 { return uint8(y) }
+
+// values expects the pixels of the mosaic along one axis to be the given
+// columns or rows of the level, -1 being NoData.
+func values(idx ...int) []uint8 {
+	v := make([]uint8, len(idx))
+	for i, x := range idx {
+		v[i] = uint8(x)
+		if x < 0 {
+			v[i] = 255
+		}
+	}
+	return v
+}
+
+func rowOf(im *image.Gray, y int) []uint8 {
+	return im.Pix[y*im.Stride : y*im.Stride+im.Rect.Dx()]
+}
+
+func columnOf(im *image.Gray, x int) []uint8 {
+	v := make([]uint8, im.Rect.Dy())
+	for y := range v {
+		v[y] = im.Pix[y*im.Stride+x]
+	}
+	return v
+}
+
+func TestMosaicRectAntimeridian(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		global bool
+		level  int
+		rect   image.Rectangle
+		want   []uint8
+	}{
+		{"west edge", true, 0, image.Rect(-3, 0, 3, 2), values(87, 88, 89, 0, 1, 2)},
+		{"east edge", true, 0, image.Rect(88, 0, 93, 2), values(88, 89, 0, 1, 2)},
+		{"beyond the east edge", true, 0, image.Rect(91, 0, 93, 2), values(1, 2)},
+		{"whole globe", true, 0, image.Rect(-1, 0, 90, 1), values(append([]int{89}, seq(0, 89)...)...)},
+		{"odd level west edge", true, 2, image.Rect(-2, 0, 2, 1), values(21, 22, 0, 1)},
+		{"odd level east edge", true, 2, image.Rect(21, 0, 25, 1), values(21, 22, 0, 1)},
+		{"coarsest level", true, 3, image.Rect(10, 0, 14, 1), values(10, 11, 0, 1)},
+		{"regional west edge", false, 0, image.Rect(-2, 0, 2, 1), values(-1, -1, 0, 1)},
+		{"regional east edge", false, 0, image.Rect(88, 0, 92, 1), values(88, 89, -1, -1)},
+	} {
+		m := testManifest(90, 45, c.global)
+		im, err := m.MosaicRect(context.Background(), c.level, c.rect, valueReader(m, column))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		for y := 0; y < im.Rect.Dy(); y++ {
+			if got := rowOf(im, y); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s: row %d is %v, want %v", c.name, y, got, c.want)
+			}
+		}
+	}
+}
+
+func TestMosaicRectPoles(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		level int
+		rect  image.Rectangle
+		want  []uint8
+	}{
+		{"north pole", 0, image.Rect(0, -2, 2, 2), values(-1, -1, 0, 1)},
+		{"south pole", 0, image.Rect(0, 43, 2, 47), values(43, 44, -1, -1)},
+		{"both poles", 0, image.Rect(0, -1, 1, 46), values(append(append([]int{-1}, seq(0, 44)...), -1)...)},
+		{"odd level south pole", 1, image.Rect(0, 21, 1, 24), values(21, 22, -1)},
+		{"entirely beyond the north pole", 0, image.Rect(0, -3, 1, -1), values(-1, -1)},
+		{"north pole across the antimeridian", 0, image.Rect(-1, -1, 1, 1), values(-1, 0)},
+	} {
+		m := testManifest(90, 45, true)
+		im, err := m.MosaicRect(context.Background(), c.level, c.rect, valueReader(m, row))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		for x := 0; x < im.Rect.Dx(); x++ {
+			if got := columnOf(im, x); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s: column %d is %v, want %v", c.name, x, got, c.want)
+			}
+		}
+	}
+}
+
+func TestMosaicBBoxEdges(t *testing.T) {
+	m := testManifest(90, 45, true)
+	for _, c := range []struct {
+		name       string
+		bbox       BBox
+		val        func(x, y int) uint8
+		cols, rows []uint8
+	}{
+		{"across the antimeridian", BBox{MinLon: 170, MinLat: -10, MaxLon: 190, MaxLat: 10}, column,
+			values(87, 88, 89, 0, 1, 2), nil},
+		{"west of the antimeridian", BBox{MinLon: -190, MinLat: -10, MaxLon: -170, MaxLat: 10}, column,
+			values(87, 88, 89, 0, 1, 2), nil},
+		{"beyond the north pole", BBox{MinLon: 0, MinLat: 80, MaxLon: 8, MaxLat: 100}, row,
+			nil, values(-1, -1, -1, 0, 1, 2)},
+		{"beyond the south pole", BBox{MinLon: 0, MinLat: -100, MaxLon: 8, MaxLat: -80}, row,
+			nil, values(42, 43, 44, -1, -1, -1)},
+	} {
+		im, err := m.MosaicBBox(context.Background(), c.bbox, valueReader(m, c.val))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if c.cols != nil {
+			if got := rowOf(im, 0); !reflect.DeepEqual(got, c.cols) {
+				t.Errorf("%s: columns %v, want %v", c.name, got, c.cols)
+			}
+		}
+		if c.rows != nil {
+			if got := columnOf(im, 0); !reflect.DeepEqual(got, c.rows) {
+				t.Errorf("%s: rows %v, want %v", c.name, got, c.rows)
+			}
+		}
+	}
+}
+
+// Resampled regions of levels whose width isn't a division of the full
+// resolution width by 2^level must wrap without shifting at the seam.
+func TestMosaicBBoxSizeSeam(t *testing.T) {
+	m := testManifest(90, 45, true)
+	bbox := BBox{MinLon: 90, MinLat: -45, MaxLon: 270, MaxLat: 45}
+	width := 10
+	level := m.levelFor(float64(width) / (bbox.MaxLon - bbox.MinLon))
+	levelWidth, _ := m.LevelSize(level)
+	if level != 2 || levelWidth != 23 {
+		t.Fatalf("Reading level %d of width %d, want level 2 of width 23", level, levelWidth)
+	}
+
+	im, err := m.MosaicBBoxSize(context.Background(), bbox, width, 5, "nearest", valueReader(m, column))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]uint8, width)
+	for i := range want {
+		lon := bbox.MinLon + (float64(i)+.5)*(bbox.MaxLon-bbox.MinLon)/float64(width)
+		want[i] = uint8(math.Mod(lon+180, 360) / 360 * float64(levelWidth))
+	}
+	if got := rowOf(im, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns %v, want %v", got, want)
+	}
+}
+
+// Boxes narrower than the snapping of pixel edges are sampled from the
+// pixels containing them.
+func TestMosaicBBoxSizeTiny(t *testing.T) {
+	m := testManifest(90, 45, true)
+	// Pixel 45, 22 starts at 0, 2
+	for _, c := range []struct {
+		bbox       BBox
+		cols, rows []uint8
+	}{
+		{BBox{MinLon: 0, MinLat: 2 - 1e-7, MaxLon: 1e-7, MaxLat: 2}, values(45, 45), values(22, 22)},
+		{BBox{MinLon: -1e-7, MinLat: 2 - 1e-7, MaxLon: 1e-7, MaxLat: 2 + 1e-7}, values(44, 45), values(21, 22)},
+		{BBox{MinLon: 180 - 1e-7, MinLat: -90, MaxLon: 180, MaxLat: -90 + 1e-7}, values(89, 89), values(44, 44)},
+	} {
+		for _, method := range ResampleMethods() {
+			im, err := m.MosaicBBoxSize(context.Background(), c.bbox, 2, 2, method, valueReader(m, column))
+			if err != nil {
+				t.Fatalf("Resampling %v with %s: %v", c.bbox, method, err)
+			}
+			// Every method reads the same value within a pixel
+			exact := method == "nearest" || c.cols[0] == c.cols[1]
+			if got := rowOf(im, 0); exact && !reflect.DeepEqual(got, c.cols) {
+				t.Errorf("Resampling %v with %s: columns %v, want %v", c.bbox, method, got, c.cols)
+			}
+			if im, err = m.MosaicBBoxSize(context.Background(), c.bbox, 2, 2, method, valueReader(m, row)); err != nil {
+				t.Fatalf("Resampling %v with %s: %v", c.bbox, method, err)
+			}
+			exact = method == "nearest" || c.rows[0] == c.rows[1]
+			if got := columnOf(im, 0); exact && !reflect.DeepEqual(got, c.rows) {
+				t.Errorf("Resampling %v with %s: rows %v, want %v", c.bbox, method, got, c.rows)
+			}
+		}
+	}
+}
+
+func TestBBoxSource(t *testing.T) {
+	m := testManifest(2880, 1440, true)
+	flat := *m
+	flat.Levels = 1
+	globe := BBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}
+	for _, c := range []struct {
+		m             *Manifest
+		bbox          BBox
+		width, height int
+		level         int
+		rect          image.Rectangle
+	}{
+		{m, globe, 90, 45, 5, image.Rect(0, 0, 90, 45)},
+		{m, globe, 100, 50, 4, image.Rect(0, 0, 180, 90)},
+		{m, BBox{MinLon: 0, MinLat: 0, MaxLon: 1, MaxLat: 1}, 8, 8, 0, image.Rect(1440, 712, 1448, 720)},
+		// Without overviews the full resolution is read whatever the size
+		{&flat, globe, 90, 45, 0, image.Rect(0, 0, 2880, 1440)},
+	} {
+		level, rect := c.m.BBoxSource(c.bbox, c.width, c.height)
+		if level != c.level || rect != c.rect {
+			t.Errorf("Resampling %v to %dx%d with %d levels reads %v of level %d, want %v of level %d",
+				c.bbox, c.width, c.height, c.m.Levels, rect, level, c.rect, c.level)
+		}
+	}
+}
+
+func TestMosaicWindowAntimeridian(t *testing.T) {
+	m := BlueMarble()
+	for _, c := range []struct {
+		lat, lon float64
+		want     image.Rectangle
+	}{
+		{0, 0, image.Rect(10600, 5200, 11000, 5600)},
+		{0, 180, image.Rect(21400, 5200, 21800, 5600)},
+		{0, -180, image.Rect(-200, 5200, 200, 5600)},
+		{90, 0, image.Rect(10600, -200, 11000, 200)},
+		{-90, 0, image.Rect(10600, 10600, 11000, 11000)},
+	} {
+		if got := m.MosaicWindow(c.lat, c.lon); got != c.want {
+			t.Errorf("MosaicWindow(%v, %v) = %v, want %v", c.lat, c.lon, got, c.want)
+		}
+	}
+}
+
+func seq(from, to int) []int {
+	var s []int
+	for i := from; i <= to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
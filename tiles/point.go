@@ -0,0 +1,81 @@
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/net/context"
+)
+
+// Point is the full resolution pixel containing a coordinate.
+type Point struct {
+	// X, Y locate the pixel in the raster.
+	X, Y int
+	// Lon, Lat are the coordinates of the centre of the pixel.
+	Lon, Lat float64
+}
+
+// Locate returns the pixel containing lat, lon. Longitudes wrap around
+// the antimeridian in global rasters. Points on the right and bottom edges
+// of the raster belong to the last column and row.
+func (m *Manifest) Locate(lat, lon float64) (Point, error) {
+	g := m.Grid()
+	x, y := g.Pixel(lon, lat)
+	x, y = snap(x), snap(y)
+	if m.Global() {
+		x = math.Mod(x, float64(m.Width))
+		if x < 0 {
+			x += float64(m.Width)
+		}
+	}
+	if x < 0 || x > float64(m.Width) || y < 0 || y > float64(m.Height) {
+		return Point{}, fmt.Errorf("Point %v, %v outside dataset %s", lat, lon, m.Name)
+	}
+	i, j := minInt(int(x), m.Width-1), minInt(int(y), m.Height-1)
+	clon, clat := g.Coord(float64(i)+.5, float64(j)+.5)
+	return Point{X: i, Y: j, Lon: clon, Lat: clat}, nil
+}
+
+// tile returns the column and row of the tile containing p.
+func (m *Manifest) tile(p Point) (int, int) {
+	return p.X / m.TileSize, p.Y / m.TileSize
+}
+
+// ReadPixel returns the value of the pixel p, reading only the tile
+// containing it.
+func (m *Manifest) ReadPixel(ctx context.Context, p Point, read TileReader) (uint8, error) {
+	values, err := m.ReadPoints(ctx, []Point{p}, read)
+	if err != nil {
+		return 0, err
+	}
+	return values[0], nil
+}
+
+// ReadPoints returns the values of the pixels pts. Points are grouped by
+// tile so each tile containing some of them is read once.
+func (m *Manifest) ReadPoints(ctx context.Context, pts []Point, read TileReader) ([]uint8, error) {
+	byTile := map[image.Point][]int{}
+	var jobs []tileJob
+	for i, p := range pts {
+		tileC, tileR := m.tile(p)
+		tp := image.Pt(tileC, tileR)
+		if _, ok := byTile[tp]; !ok {
+			jobs = append(jobs, tileJob{tileC: tileC, tileR: tileR})
+		}
+		byTile[tp] = append(byTile[tp], i)
+	}
+
+	values := make([]uint8, len(pts))
+	err := readTiles(ctx, 0, jobs, read, func(job tileJob, tile *image.Gray) {
+		// Tiles hold disjoint sets of points
+		for _, i := range byTile[image.Pt(job.tileC, job.tileR)] {
+			p := pts[i]
+			values[i] = tile.GrayAt(tile.Rect.Min.X+p.X-job.tileC*m.TileSize, tile.Rect.Min.Y+p.Y-job.tileR*m.TileSize).Y
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
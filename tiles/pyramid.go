@@ -0,0 +1,126 @@
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"sort"
+
+	"github.com/prl900/earth_data_server/store"
+	"golang.org/x/net/context"
+)
+
+// Overview level n halves the resolution of level n-1. Level 0 contains
+// the full resolution tiles. Every level is split in TileSize x TileSize
+// tiles, the ones on the right and bottom edges padded with zeros.
+
+// LevelSize returns the size in pixels of the raster at an overview level.
+func (m *Manifest) LevelSize(level int) (width, height int) {
+	width, height = m.Width, m.Height
+	for l := 0; l < level; l++ {
+		width, height = (width+1)/2, (height+1)/2
+	}
+	return
+}
+
+// NumLevels returns the number of levels of a complete pyramid, the last
+// one fitting in a single tile.
+func (m *Manifest) NumLevels() int {
+	n := 1
+	for w, h := m.LevelSize(0); w > m.TileSize || h > m.TileSize; n++ {
+		w, h = (w+1)/2, (h+1)/2
+	}
+	return n
+}
+
+// levelFor returns the coarsest of the available levels with at least
+// pixDeg pixels per degree.
+func (m *Manifest) levelFor(pixDeg float64) int {
+	level := 0
+	for level+1 < m.Levels && 1/m.LevelGrid(level+1).PixelWidth >= pixDeg {
+		level++
+	}
+	return level
+}
+
+// aggregators combine the pixels of a 2x2 block, fewer on the edges.
+var aggregators = map[string]func(px []uint8) uint8{
+	"nearest": func(px []uint8) uint8 { return px[0] },
+	"mean": func(px []uint8) uint8 {
+		sum := 0
+		for _, p := range px {
+			sum += int(p)
+		}
+		return uint8((sum + len(px)/2) / len(px))
+	},
+	"min": func(px []uint8) uint8 {
+		m := px[0]
+		for _, p := range px[1:] {
+			if p < m {
+				m = p
+			}
+		}
+		return m
+	},
+	"max": func(px []uint8) uint8 {
+		m := px[0]
+		for _, p := range px[1:] {
+			if p > m {
+				m = p
+			}
+		}
+		return m
+	},
+}
+
+// Aggregations returns the sorted names of the overview aggregations.
+func Aggregations() []string {
+	names := make([]string, 0, len(aggregators))
+	for name := range aggregators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Downsample halves the resolution of img combining each 2x2 block of
+// pixels with the named aggregation: nearest, mean, min or max.
+func Downsample(img *image.Gray, aggregation string) (*image.Gray, error) {
+	agg, ok := aggregators[aggregation]
+	if !ok {
+		return nil, fmt.Errorf("Unknown aggregation: %q", aggregation)
+	}
+	b := img.Bounds()
+	out := image.NewGray(image.Rect(0, 0, (b.Dx()+1)/2, (b.Dy()+1)/2))
+	px := make([]uint8, 0, 4)
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			px = px[:0]
+			for sy := 2 * y; sy < 2*y+2 && sy < b.Dy(); sy++ {
+				for sx := 2 * x; sx < 2*x+2 && sx < b.Dx(); sx++ {
+					px = append(px, img.GrayAt(b.Min.X+sx, b.Min.Y+sy).Y)
+				}
+			}
+			out.Pix[y*out.Stride+x] = agg(px)
+		}
+	}
+	return out, nil
+}
+
+// GenerateOverviews writes the tiles of the overview levels of a single
+// band, up to m.Levels, built with the named aggregation, into st. The
+// full resolution tiles are written by GenerateTiles.
+func (m *Manifest) GenerateOverviews(ctx context.Context, st store.TileStore, img *image.Gray, band int, codecName, aggregation string) error {
+	if _, ok := aggregators[aggregation]; !ok {
+		return fmt.Errorf("Unknown aggregation: %q", aggregation)
+	}
+	var err error
+	for level := 1; level < m.Levels; level++ {
+		if img, err = Downsample(img, aggregation); err != nil {
+			return err
+		}
+		if err = m.generateLevel(ctx, st, img, level, band, codecName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package tiles
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLevelSize(t *testing.T) {
+	for _, c := range []struct {
+		width, height, level int
+		wantW, wantH         int
+	}{
+		{21600, 10800, 0, 21600, 10800},
+		{21600, 10800, 1, 10800, 5400},
+		{21600, 10800, 5, 675, 338},
+		{21600, 10800, 6, 338, 169},
+		{90, 45, 1, 45, 23},
+		{90, 45, 2, 23, 12},
+		{90, 45, 3, 12, 6},
+		{1, 1, 4, 1, 1},
+	} {
+		m := &Manifest{Width: c.width, Height: c.height}
+		if w, h := m.LevelSize(c.level); w != c.wantW || h != c.wantH {
+			t.Errorf("LevelSize(%d) of %dx%d = %dx%d, want %dx%d", c.level, c.width, c.height, w, h, c.wantW, c.wantH)
+		}
+	}
+}
+
+func TestNumLevels(t *testing.T) {
+	for _, c := range []struct {
+		width, height, tileSize int
+		want                    int
+	}{
+		{21600, 10800, 400, 7},
+		{400, 400, 400, 1},
+		{401, 10, 400, 2},
+		{90, 45, 16, 4},
+	} {
+		m := &Manifest{Width: c.width, Height: c.height, TileSize: c.tileSize}
+		if n := m.NumLevels(); n != c.want {
+			t.Errorf("NumLevels of %dx%d in %d tiles = %d, want %d", c.width, c.height, c.tileSize, n, c.want)
+		}
+	}
+}
+
+// Every level must cover the extent of the raster, including the levels
+// whose size isn't the full resolution size divided by 2^level.
+func TestLevelGridExtent(t *testing.T) {
+	for _, m := range []*Manifest{BlueMarble(), testManifest(90, 45, true), testManifest(90, 45, false)} {
+		b := m.BBox()
+		for level := 0; level < 8; level++ {
+			width, height := m.LevelSize(level)
+			x, y := m.LevelGrid(level).Pixel(b.MaxLon, b.MinLat)
+			if math.Abs(x-float64(width)) > 1e-9 || math.Abs(y-float64(height)) > 1e-9 {
+				t.Errorf("%s level %d: bottom right corner at pixel %v, %v, want %d, %d", m.Name, level, x, y, width, height)
+			}
+		}
+	}
+	if g := BlueMarble().LevelGrid(0); g.PixelWidth != 1./60 || g.PixelHeight != -1./60 {
+		t.Errorf("Full resolution grid %v, want the manifest geotransform", g)
+	}
+}
+
+func TestLevelFor(t *testing.T) {
+	m := testManifest(90, 45, true)
+	for _, c := range []struct {
+		pixDeg float64
+		want   int
+	}{
+		{1, 0},
+		{.25, 0},
+		{.12, 1},
+		{.125, 1},
+		{.06, 2},
+		{.01, 3},
+	} {
+		if level := m.levelFor(c.pixDeg); level != c.want {
+			t.Errorf("levelFor(%v) = %d, want %d", c.pixDeg, level, c.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// tap is the contribution of a source pixel to an output pixel.
+type tap struct {
+	i int
+	w float64
+}
+
+// weightsFunc returns the taps of each of the n output pixels sampling the
+// source interval [a, b) of a row or column with srcN pixels.
+type weightsFunc func(srcN int, a, b float64, n int) [][]tap
+
+var resamplers = map[string]weightsFunc{
+	"nearest":  nearestWeights,
+	"bilinear": kernelWeights(1, triangle),
+	"cubic":    kernelWeights(2, catmullRom),
+	"average":  averageWeights,
+}
+
+// ResampleMethods returns the sorted names of the resampling methods.
+func ResampleMethods() []string {
+	names := make([]string, 0, len(resamplers))
+	for name := range resamplers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+func nearestWeights(srcN int, a, b float64, n int) [][]tap {
+	scale := (b - a) / float64(n)
+	taps := make([][]tap, n)
+	for o := range taps {
+		c := a + (float64(o)+.5)*scale
+		taps[o] = []tap{{clamp(int(math.Floor(c)), srcN), 1}}
+	}
+	return taps
+}
+
+func triangle(x float64) float64 {
+	x = math.Abs(x)
+	if x < 1 {
+		return 1 - x
+	}
+	return 0
+}
+
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+// kernelWeights interpolates between the source pixel centres with a
+// kernel of the given support radius.
+func kernelWeights(support int, kernel func(float64) float64) weightsFunc {
+	return func(srcN int, a, b float64, n int) [][]tap {
+		scale := (b - a) / float64(n)
+		taps := make([][]tap, n)
+		for o := range taps {
+			x := a + (float64(o)+.5)*scale - .5
+			k0 := int(math.Floor(x))
+			sum := 0.
+			for k := k0 - support + 1; k <= k0+support; k++ {
+				w := kernel(x - float64(k))
+				if w == 0 {
+					continue
+				}
+				taps[o] = append(taps[o], tap{clamp(k, srcN), w})
+				sum += w
+			}
+			for i := range taps[o] {
+				taps[o][i].w /= sum
+			}
+		}
+		return taps
+	}
+}
+
+// averageWeights weights every source pixel by the fraction of the output
+// pixel footprint it covers.
+func averageWeights(srcN int, a, b float64, n int) [][]tap {
+	scale := (b - a) / float64(n)
+	taps := make([][]tap, n)
+	for o := range taps {
+		lo := a + float64(o)*scale
+		hi := lo + scale
+		for k := int(math.Floor(lo)); float64(k) < hi; k++ {
+			w := math.Min(hi, float64(k+1)) - math.Max(lo, float64(k))
+			if w > 0 {
+				taps[o] = append(taps[o], tap{clamp(k, srcN), w / scale})
+			}
+		}
+	}
+	return taps
+}
+
+// resampleWindow resizes the window [x0, x1) x [y0, y1) of src, given in
+// fractional pixel coordinates relative to its bounds, to width x height.
+func resampleWindow(src *image.Gray, x0, y0, x1, y1 float64, width, height int, method string) (*image.Gray, error) {
+	weights, ok := resamplers[method]
+	if !ok {
+		return nil, fmt.Errorf("Unknown resampling method: %q", method)
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("Invalid output size: %dx%d", width, height)
+	}
+	b := src.Bounds()
+	xTaps := weights(b.Dx(), x0, x1, width)
+	yTaps := weights(b.Dy(), y0, y1, height)
+
+	// Horizontal pass into a float buffer with the source rows, followed
+	// by the vertical pass into the output.
+	tmp := make([]float64, b.Dy()*width)
+	for y := 0; y < b.Dy(); y++ {
+		row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x, taps := range xTaps {
+			v := 0.
+			for _, t := range taps {
+				v += float64(row[t.i]) * t.w
+			}
+			tmp[y*width+x] = v
+		}
+	}
+
+	dst := image.NewGray(image.Rect(0, 0, width, height))
+	for y, taps := range yTaps {
+		for x := 0; x < width; x++ {
+			v := 0.
+			for _, t := range taps {
+				v += tmp[t.i*width+x] * t.w
+			}
+			dst.Pix[y*dst.Stride+x] = uint8(math.Max(0, math.Min(255, v+.5)))
+		}
+	}
+	return dst, nil
+}
+
+// Resample resizes src to width x height using the named method: nearest,
+// bilinear, cubic or average. Average computes the area weighted mean of
+// the source pixels and is the method of choice when downsampling.
+func Resample(src *image.Gray, width, height int, method string) (*image.Gray, error) {
+	b := src.Bounds()
+	return resampleWindow(src, 0, 0, float64(b.Dx()), float64(b.Dy()), width, height, method)
+}
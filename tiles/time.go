@@ -0,0 +1,78 @@
+package tiles
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// TimeLayout is the layout of the dates of a time series in manifests.
+const TimeLayout = "2006-01-02"
+
+// timeLayouts are the layouts accepted by ParseTime, the shortest ones
+// referring to the start of the month.
+var timeLayouts = []string{TimeLayout, "2006-01", "20060102", "200601", time.RFC3339}
+
+// ParseTime parses a date such as 2004-03-01, 2004-03, 20040301 or 200403.
+func ParseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %q, expecting a date such as 2004-03-01 or 200403", s)
+}
+
+// validateTimes checks that the times of the manifest are increasing dates.
+func (m *Manifest) validateTimes() error {
+	var prev time.Time
+	for i, s := range m.Times {
+		t, err := time.Parse(TimeLayout, s)
+		if err != nil {
+			return fmt.Errorf("Invalid time %q, expecting %s", s, TimeLayout)
+		}
+		if i > 0 && !t.After(prev) {
+			return fmt.Errorf("Times not increasing: %s, %s", m.Times[i-1], s)
+		}
+		prev = t
+	}
+	return nil
+}
+
+// At returns the manifest of the raster of the time series at index i.
+// Its tiles are kept under the date of the raster as prefix.
+func (m *Manifest) At(i int) *Manifest {
+	at := *m
+	at.Times = nil
+	at.TileName = m.Times[i] + "/" + m.TileName
+	at.OverviewName = m.Times[i] + "/" + m.OverviewName
+	return &at
+}
+
+// Time returns the index of the raster of the time series at the date s:
+// the date itself or, if nearest, the closest one.
+func (m *Manifest) Time(s string, nearest bool) (int, error) {
+	if len(m.Times) == 0 {
+		return 0, fmt.Errorf("Dataset %s has no time dimension", m.Name)
+	}
+	t, err := ParseTime(s)
+	if err != nil {
+		return 0, err
+	}
+	best, bestDiff := -1, math.Inf(1)
+	for i, ts := range m.Times {
+		ti, _ := time.Parse(TimeLayout, ts)
+		diff := math.Abs(t.Sub(ti).Hours())
+		if diff == 0 {
+			return i, nil
+		}
+		if diff < bestDiff {
+			best, bestDiff = i, diff
+		}
+	}
+	if !nearest {
+		return 0, fmt.Errorf("No raster at %s, available times: %s", s, strings.Join(m.Times, ", "))
+	}
+	return best, nil
+}
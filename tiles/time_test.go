@@ -0,0 +1,125 @@
+package tiles
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	march := time.Date(2004, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		s    string
+		want time.Time
+		ok   bool
+	}{
+		{"2004-03-01", march, true},
+		{"2004-03-15", march.AddDate(0, 0, 14), true},
+		// Months refer to their first day
+		{"2004-03", march, true},
+		{"20040301", march, true},
+		{"200403", march, true},
+		{"2004-03-01T12:00:00Z", march.Add(12 * time.Hour), true},
+		{"2004-02-29", march.AddDate(0, 0, -1), true},
+		{"2003-02-29", time.Time{}, false},
+		{"2004-13-01", time.Time{}, false},
+		{"2004-03-32", time.Time{}, false},
+		{"2004", time.Time{}, false},
+		{"01/03/2004", time.Time{}, false},
+		{"2004-03-01 12:00", time.Time{}, false},
+		{"", time.Time{}, false},
+	} {
+		got, err := ParseTime(c.s)
+		if (err == nil) != c.ok {
+			t.Errorf("ParseTime(%q) error %v, want ok %v", c.s, err, c.ok)
+			continue
+		}
+		if c.ok && !got.Equal(c.want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", c.s, got, c.want)
+		}
+	}
+	// The dates of the manifests parse back to themselves
+	for _, s := range []string{"2004-01-01", "2004-12-31"} {
+		if tm, err := ParseTime(s); err != nil || tm.Format(TimeLayout) != s {
+			t.Errorf("ParseTime(%q) = %v, %v", s, tm, err)
+		}
+	}
+}
+
+func TestValidateTimes(t *testing.T) {
+	for _, c := range []struct {
+		times []string
+		err   string
+	}{
+		{nil, ""},
+		{[]string{"2004-01-01"}, ""},
+		{[]string{"2004-01-01", "2004-01-09", "2004-02-01"}, ""},
+		{[]string{"2004-02-01", "2004-01-01"}, "Times not increasing"},
+		{[]string{"2004-01-01", "2004-02-01", "2004-02-01"}, "Times not increasing"},
+		// Manifests only hold full dates
+		{[]string{"2004-01"}, "Invalid time"},
+		{[]string{"20040101"}, "Invalid time"},
+		{[]string{"2004-01-01", "2004-02-30"}, "Invalid time"},
+	} {
+		m := testManifest(90, 45, true)
+		m.Times = c.times
+		err := m.validateTimes()
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.HasPrefix(err.Error(), c.err)) {
+			t.Errorf("validateTimes with times %v: %v, want %q", c.times, err, c.err)
+		}
+	}
+}
+
+func TestManifestTime(t *testing.T) {
+	m := testManifest(90, 45, true)
+	m.Times = []string{"2004-01-01", "2004-02-01", "2004-03-01", "2005-03-01"}
+	for _, c := range []struct {
+		s       string
+		nearest bool
+		want    int
+		ok      bool
+	}{
+		{"2004-02-01", false, 1, true},
+		{"200402", false, 1, true},
+		{"2004-03", true, 2, true},
+		{"2004-02-10", false, 0, false},
+		{"2004-02-10", true, 1, true},
+		{"2004-02-20", true, 2, true},
+		// Before the first and after the last dates
+		{"1999-01-01", true, 0, true},
+		{"2010-01-01", true, 3, true},
+		{"2010-01-01", false, 0, false},
+		// Ties go to the earlier date
+		{"2004-01-16T12:00:00Z", true, 0, true},
+		{"2004-02-30", true, 0, false},
+	} {
+		i, err := m.Time(c.s, c.nearest)
+		if (err == nil) != c.ok {
+			t.Errorf("Time(%q, %v) error %v, want ok %v", c.s, c.nearest, err, c.ok)
+			continue
+		}
+		if c.ok && i != c.want {
+			t.Errorf("Time(%q, %v) = %d, want %d", c.s, c.nearest, i, c.want)
+		}
+	}
+	if _, err := m.Time("2004-02-10", false); err == nil || !strings.Contains(err.Error(), strings.Join(m.Times, ", ")) {
+		t.Errorf("Error %v doesn't list the available times", err)
+	}
+
+	if _, err := testManifest(90, 45, true).Time("2004-01-01", true); err == nil {
+		t.Errorf("Found a time in a dataset without times")
+	}
+}
+
+func TestManifestAt(t *testing.T) {
+	m := testManifest(90, 45, true)
+	m.Times = []string{"2004-01-01", "2004-02-01"}
+	m.TileName, m.OverviewName = "tile.%02d.%02d.%s", "tile.l%d.%02d.%02d.%s"
+	at := m.At(1)
+	if at.Times != nil || at.TileName != "2004-02-01/"+m.TileName || at.OverviewName != "2004-02-01/"+m.OverviewName {
+		t.Errorf("At(1) has times %v and tile names %q, %q", at.Times, at.TileName, at.OverviewName)
+	}
+	if at.Name != m.Name || at.Width != m.Width || len(m.Times) != 2 || m.TileName != "tile.%02d.%02d.%s" {
+		t.Errorf("At(1) = %+v of %+v", at, m)
+	}
+}